@@ -0,0 +1,44 @@
+#include <chrono>
+#include <cstdlib>
+#include <iostream>
+#include <sstream>
+#include <string>
+
+using namespace chisel;
+
+static const char *keywords[] = {
+	"auto", "break", "case", "char", "const",
+	"continue", "default", "do", "double", "else",
+	"enum", "extern", "float", "for", "goto",
+	"if", "int", "long", "return", "while",
+};
+
+int main(int argc, char **argv) {
+	size_t count = argc > 1 ? std::strtoul(argv[1], nullptr, 10) : 200000;
+
+	// A fixed linear congruential sequence, so every run parses the same input.
+	std::string input;
+	unsigned seed = 1;
+	for (size_t i = 0; i < count; ++i) {
+		seed = seed * 1103515245 + 12345;
+		input += keywords[(seed >> 16) % 20];
+		input += ' ';
+	}
+
+	std::istringstream in(input);
+	Reader reader(in);
+	Parser parser(reader);
+
+	auto start = std::chrono::steady_clock::now();
+	auto outcome = parser.parse();
+	auto end = std::chrono::steady_clock::now();
+
+	if (!outcome) {
+		for (const auto &diagnostic : outcome.diagnostics())
+			std::cerr << diagnostic.str() << std::endl;
+		return 1;
+	}
+
+	auto ms = std::chrono::duration_cast<std::chrono::milliseconds>(end - start).count();
+	std::cout << outcome.tree().node().size() << " statements in " << ms << " ms" << std::endl;
+}
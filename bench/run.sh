@@ -0,0 +1,20 @@
+#!/bin/sh
+# Compares the trial and predictive parsers generated for bench.chisel.
+# usage: bench/run.sh [statements]
+set -e
+cd "$(dirname "$0")/.."
+
+out=$(mktemp -d)
+trap 'rm -rf "$out"' EXIT
+
+go build -o "$out/chisel" .
+for mode in trial predictive; do
+	flags=""
+	if [ "$mode" = predictive ]; then
+		flags="-predictive"
+	fi
+	"$out/chisel" $flags -o "$out/$mode.hpp" -v "$out/${mode}_visitor.hpp" bench/bench.chisel >/dev/null
+	${CXX:-c++} -std=c++20 -O2 -include "$out/$mode.hpp" bench/main.cpp -o "$out/$mode"
+	printf '%-12s' "$mode:"
+	"$out/$mode" "${1:-200000}"
+done
@@ -0,0 +1,503 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+ * Grammar analysis over the realized constructs:
+ *
+ * nullable: can a construct match without consuming input
+ * FIRST:    the tokens a construct can start with
+ * FOLLOW:   the tokens that can come right after a construct
+ *
+ * With those every decision the generated parser makes (which alternative,
+ * whether to repeat, whether to take an optional part) is checked for LL(1)
+ * conflicts: tokens that don't tell the choices apart, which the generated
+ * code can only settle by trying one choice after another.
+ */
+
+// endOfInput stands for the end of the input in FOLLOW sets. It can't clash
+// with a token name.
+const endOfInput = "<EOF>"
+
+type ConflictKind int
+
+const (
+	ALTERNATIVE_CONFLICT ConflictKind = iota
+	REPETITION_CONFLICT
+	OPTIONAL_CONFLICT
+	SEPARATOR_CONFLICT
+	OPERATOR_CONFLICT
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case ALTERNATIVE_CONFLICT:
+		return "alternatives"
+	case REPETITION_CONFLICT:
+		return "repetition"
+	case OPTIONAL_CONFLICT:
+		return "optional"
+	case SEPARATOR_CONFLICT:
+		return "separator"
+	case OPERATOR_CONFLICT:
+		return "operator"
+	default:
+		return fmt.Sprintf("ConflictKind(%d)", int(k))
+	}
+}
+
+func (k ConflictKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ConstructSets holds the analysis of one construct.
+type ConstructSets struct {
+	Name     string   `json:"name"`
+	Nullable bool     `json:"nullable"`
+	First    []string `json:"first"`
+	Follow   []string `json:"follow"`
+}
+
+// Choice is one of the ways a parser can go at a conflict, with a shortest
+// input that takes it.
+type Choice struct {
+	Description string   `json:"description"`
+	Example     []string `json:"example"`
+}
+
+// Conflict is a decision that one token of lookahead can't make.
+type Conflict struct {
+	Construct string       `json:"construct"`
+	Kind      ConflictKind `json:"kind"`
+	Pos       Position     `json:"-"`
+	Location  string       `json:"location"`
+	Tokens    []string     `json:"tokens"`
+	Choices   []Choice     `json:"choices"`
+}
+
+type Analysis struct {
+	Constructs []ConstructSets `json:"constructs"`
+	Conflicts  []Conflict      `json:"conflicts"`
+}
+
+// LL1 reports whether every decision can be made on the next token.
+func (a *Analysis) LL1() bool {
+	return len(a.Conflicts) == 0
+}
+
+// WriteText writes the analysis in a human readable form.
+func (a *Analysis) WriteText(w io.Writer) error {
+	var s strings.Builder
+	for _, c := range a.Constructs {
+		nullable := "no"
+		if c.Nullable {
+			nullable = "yes"
+		}
+		fmt.Fprintf(&s, "%s\n\tnullable: %s\n\tfirst:    %s\n\tfollow:   %s\n\n", c.Name, nullable, strings.Join(c.First, " "), strings.Join(c.Follow, " "))
+	}
+
+	if a.LL1() {
+		s.WriteString("No LL(1) conflicts.\n")
+	} else {
+		fmt.Fprintf(&s, "%d LL(1) conflict(s):\n", len(a.Conflicts))
+	}
+	for _, c := range a.Conflicts {
+		fmt.Fprintf(&s, "%s: %s conflict in '%s' on %s\n", c.Location, c.Kind, c.Construct, strings.Join(c.Tokens, " "))
+		for _, choice := range c.Choices {
+			fmt.Fprintf(&s, "\t%s\n", choice.Description)
+			if len(choice.Example) > 0 {
+				fmt.Fprintf(&s, "\t\te.g. %s\n", strings.Join(choice.Example, " "))
+			}
+		}
+	}
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+type tokenSet map[string]bool
+
+func (s tokenSet) union(o tokenSet) bool {
+	changed := false
+	for t := range o {
+		if !s[t] {
+			s[t] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s tokenSet) intersect(o tokenSet) tokenSet {
+	res := tokenSet{}
+	for t := range s {
+		if o[t] {
+			res[t] = true
+		}
+	}
+	return res
+}
+
+func (s tokenSet) with(o tokenSet) tokenSet {
+	res := tokenSet{}
+	res.union(s)
+	res.union(o)
+	return res
+}
+
+type analyzer struct {
+	constructs map[string]*Construct
+	nullable   map[string]bool
+	first      map[string]tokenSet
+	follow     map[string]tokenSet
+	shortest   map[string][]string
+	// changed is set whenever walk grows a FOLLOW set.
+	changed bool
+
+	// Where conflicts are reported from, nil while FOLLOW is computed.
+	current   *Construct
+	conflicts []Conflict
+
+	display map[string]string
+	order   map[string]int
+}
+
+// newAnalyzer computes which constructs are nullable and their FIRST sets.
+func newAnalyzer(tokens []Token, constructs []Construct) *analyzer {
+	a := &analyzer{
+		constructs: map[string]*Construct{},
+		nullable:   nullableConstructs(constructs),
+		first:      map[string]tokenSet{},
+		follow:     map[string]tokenSet{},
+		display:    map[string]string{endOfInput: endOfInput},
+		order:      map[string]int{endOfInput: len(tokens)},
+	}
+	for i, tok := range tokens {
+		a.display[tok.Name()] = tok.DisplayName()
+		a.order[tok.Name()] = i
+	}
+	for i := range constructs {
+		c := &constructs[i]
+		a.constructs[c.Name()] = c
+		a.first[c.Name()] = tokenSet{}
+		a.follow[c.Name()] = tokenSet{}
+		if c.EntryPoint {
+			a.follow[c.Name()][endOfInput] = true
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, c := range constructs {
+			if a.first[c.Name()].union(a.firstOf(c.Value)) {
+				changed = true
+			}
+		}
+	}
+	return a
+}
+
+// follows computes the FOLLOW sets of every construct.
+func (a *analyzer) follows(constructs []Construct) {
+	for a.changed = true; a.changed; {
+		a.changed = false
+		for _, c := range constructs {
+			a.walk(c.Value, a.follow[c.Name()].with(nil))
+		}
+	}
+}
+
+func analyze(tokens []Token, constructs []Construct) *Analysis {
+	a := newAnalyzer(tokens, constructs)
+	a.follows(constructs)
+	a.shortest = shortestSentences(constructs)
+
+	res := &Analysis{
+		Constructs: []ConstructSets{},
+		Conflicts:  []Conflict{},
+	}
+	for i := range constructs {
+		c := &constructs[i]
+		a.current = c
+		a.walk(c.Value, a.follow[c.Name()])
+		res.Constructs = append(res.Constructs, ConstructSets{
+			Name:     c.Name(),
+			Nullable: a.nullable[c.Name()],
+			First:    a.list(a.first[c.Name()]),
+			Follow:   a.list(a.follow[c.Name()]),
+		})
+	}
+	res.Conflicts = a.conflicts
+	return res
+}
+
+// sorted lists the tokens of s in declaration order.
+func (a *analyzer) sorted(s tokenSet) []string {
+	names := []string{}
+	for t := range s {
+		names = append(names, t)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return a.order[names[i]] < a.order[names[j]]
+	})
+	return names
+}
+
+// anything is the set of every token and the end of input.
+func (a *analyzer) anything() tokenSet {
+	res := tokenSet{}
+	for t := range a.order {
+		res[t] = true
+	}
+	return res
+}
+
+// list renders a token set in declaration order.
+func (a *analyzer) list(s tokenSet) []string {
+	names := a.sorted(s)
+	for i, n := range names {
+		names[i] = a.display[n]
+	}
+	return names
+}
+
+// firstOf is the set of tokens t can start with.
+func (a *analyzer) firstOf(t Transpilable) tokenSet {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return tokenSet{r.Name(): true}
+	case *NestedRegex:
+		return a.first[r.Inner].with(nil)
+	case *PredicateRegex:
+		return tokenSet{}
+	case *FieldRegex:
+		return a.firstOf(r.Inner)
+	case *CapturedRegex:
+		return a.firstOf(r.Inner)
+	case *OptionalRegex:
+		return a.firstOf(r.Inner)
+	case *RepeatRegex:
+		return a.firstOf(r.Inner)
+	case *MultiplierRegex:
+		return a.firstOf(r.Inner)
+	case *SeparatedRegex:
+		res := a.firstOf(r.Item)
+		if nullable(r.Item, a.nullable) {
+			res.union(a.firstOf(r.Separator))
+		}
+		return res
+	case *OperatorRegex:
+		res := a.firstOf(r.Operand)
+		for _, l := range r.Levels {
+			if l.Fixity == PREFIX_OPERATOR {
+				for _, op := range l.Operators {
+					res[op.Name()] = true
+				}
+			}
+		}
+		return res
+	case *LeftRecursiveRegex:
+		res := a.firstOf(r.Base)
+		if nullable(r.Base, a.nullable) {
+			res.union(a.tailsFirst(r))
+		}
+		return res
+	case *ChainRegex:
+		res := tokenSet{}
+		for _, c := range r.Chain {
+			res.union(a.firstOf(c))
+			if !nullable(c, a.nullable) {
+				break
+			}
+		}
+		return res
+	case *OrRegex:
+		res := tokenSet{}
+		for _, c := range r.Chain {
+			res.union(a.firstOf(c))
+		}
+		return res
+	default:
+		return tokenSet{}
+	}
+}
+
+func (a *analyzer) tailsFirst(r *LeftRecursiveRegex) tokenSet {
+	res := tokenSet{}
+	for _, t := range r.Tails {
+		res.union(a.firstOf(t.Tail))
+	}
+	return res
+}
+
+// infixOperators are the operators a table looks for after an operand.
+func infixOperators(r *OperatorRegex) tokenSet {
+	res := tokenSet{}
+	for _, l := range r.Levels {
+		if l.Fixity != PREFIX_OPERATOR {
+			for _, op := range l.Operators {
+				res[op.Name()] = true
+			}
+		}
+	}
+	return res
+}
+
+// walk visits t knowing next, the tokens that can come right after it. It
+// grows the FOLLOW sets of the constructs t calls and, once a.current is set,
+// reports the conflicts in t.
+func (a *analyzer) walk(t Transpilable, next tokenSet) {
+	switch r := t.(type) {
+	case *NestedRegex:
+		if a.follow[r.Inner].union(next) {
+			a.changed = true
+		}
+	case *PredicateRegex:
+		// A lookahead only looks at a prefix of what comes next.
+		a.walk(r.Inner, a.anything())
+	case *FieldRegex:
+		a.walk(r.Inner, next)
+	case *CapturedRegex:
+		a.walk(r.Inner, next)
+	case *OptionalRegex:
+		a.loop(OPTIONAL_CONFLICT, r.Pos, r.Inner, next, "take the optional "+describe(r.Inner))
+		a.walk(r.Inner, next)
+	case *MultiplierRegex:
+		a.loop(REPETITION_CONFLICT, r.Pos, r.Inner, next, "repeat "+describe(r.Inner))
+		a.walk(r.Inner, a.firstOf(r.Inner).with(next))
+	case *RepeatRegex:
+		if r.Min != r.Max {
+			a.loop(REPETITION_CONFLICT, r.Pos, r.Inner, next, "repeat "+describe(r.Inner))
+		}
+		if r.Max == 1 {
+			a.walk(r.Inner, next)
+		} else {
+			a.walk(r.Inner, a.firstOf(r.Inner).with(next))
+		}
+	case *SeparatedRegex:
+		a.loop(SEPARATOR_CONFLICT, r.Pos, r.Separator, next, "continue the list with "+describe(r.Separator))
+		if r.Trailing {
+			a.loop(SEPARATOR_CONFLICT, r.Pos, r.Item, next, "continue the list with "+describe(r.Item))
+		}
+		a.walk(r.Item, a.firstOf(r.Separator).with(next))
+		afterSeparator := a.firstOf(r.Item)
+		if r.Trailing || nullable(r.Item, a.nullable) {
+			afterSeparator.union(next)
+		}
+		a.walk(r.Separator, afterSeparator)
+	case *OperatorRegex:
+		infix := infixOperators(r)
+		if a.current != nil {
+			if both := infix.intersect(next); len(both) > 0 {
+				a.report(OPERATOR_CONFLICT, r.Pos, both, []Choice{
+					{Description: "apply an operator of " + a.current.Name()},
+					{Description: "end " + a.current.Name()},
+				})
+			}
+		}
+		a.walk(r.Operand, infix.with(next))
+	case *LeftRecursiveRegex:
+		tails := a.tailsFirst(r)
+		afterStep := tails.with(next)
+		a.walk(r.Base, afterStep)
+		for _, t := range r.Tails {
+			a.walk(t.Tail, afterStep)
+		}
+		choices := make([]Transpilable, len(r.Tails))
+		for i, t := range r.Tails {
+			choices[i] = t.Tail
+		}
+		a.alternatives(r.Pos, choices, afterStep)
+		if a.current != nil {
+			if both := tails.intersect(next); len(both) > 0 {
+				a.report(REPETITION_CONFLICT, r.Pos, both, []Choice{
+					{Description: "extend " + r.Construct},
+					{Description: "end " + r.Construct},
+				})
+			}
+		}
+	case *ChainRegex:
+		after := next
+		for i := len(r.Chain) - 1; i >= 0; i-- {
+			c := r.Chain[i]
+			a.walk(c, after)
+			first := a.firstOf(c)
+			if nullable(c, a.nullable) {
+				first.union(after)
+			}
+			after = first
+		}
+	case *OrRegex:
+		for _, c := range r.Chain {
+			a.walk(c, next)
+		}
+		a.alternatives(r.Pos, r.Chain, next)
+	}
+}
+
+// loop checks the decision to go into inner (once more) or to move on to
+// whatever follows.
+func (a *analyzer) loop(kind ConflictKind, pos Position, inner Transpilable, next tokenSet, enter string) {
+	if a.current == nil {
+		return
+	}
+	both := a.firstOf(inner).intersect(next)
+	if len(both) == 0 {
+		return
+	}
+	tok := a.sorted(both)[0]
+	a.report(kind, pos, both, []Choice{
+		{Description: enter, Example: a.example(inner, tok)},
+		{Description: "move on", Example: []string{a.display[tok]}},
+	})
+}
+
+// alternatives checks that the next token picks at most one of alts.
+func (a *analyzer) alternatives(pos Position, alts []Transpilable, next tokenSet) {
+	if a.current == nil {
+		return
+	}
+	firsts := make([]tokenSet, len(alts))
+	for i, alt := range alts {
+		firsts[i] = a.firstOf(alt)
+		if nullable(alt, a.nullable) {
+			firsts[i].union(next)
+		}
+	}
+	for i := range alts {
+		for j := i + 1; j < len(alts); j++ {
+			both := firsts[i].intersect(firsts[j])
+			if len(both) == 0 {
+				continue
+			}
+			tok := a.sorted(both)[0]
+			a.report(ALTERNATIVE_CONFLICT, pos, both, []Choice{
+				{Description: fmt.Sprintf("alternative %d: %s", i+1, describe(alts[i])), Example: a.example(alts[i], tok)},
+				{Description: fmt.Sprintf("alternative %d: %s", j+1, describe(alts[j])), Example: a.example(alts[j], tok)},
+			})
+		}
+	}
+}
+
+func (a *analyzer) report(kind ConflictKind, pos Position, tokens tokenSet, choices []Choice) {
+	if !pos.IsValid() {
+		pos = a.current.Pos
+	}
+	for i := range choices {
+		if choices[i].Example == nil {
+			choices[i].Example = []string{}
+		}
+	}
+	a.conflicts = append(a.conflicts, Conflict{
+		Construct: a.current.Name(),
+		Kind:      kind,
+		Pos:       pos,
+		Location:  pos.String(),
+		Tokens:    a.list(tokens),
+		Choices:   choices,
+	})
+}
@@ -0,0 +1,141 @@
+package grammar
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conflictSummary is the part of a conflict the analysis tests check: its
+// kind, construct and tokens, like "alternatives stmt ID".
+func conflictSummary(c Conflict) string {
+	return c.Kind.String() + " " + c.Construct + " " + strings.Join(c.Tokens, " ")
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name string
+		// files are written next to each other, the grammar analyzed is
+		// main.chisel.
+		files      map[string]string
+		constructs []ConstructSets
+		conflicts  []string
+	}{
+		{
+			name: "LL(1)",
+			files: map[string]string{"main.chisel": `
+				tok ID = /[a-z]+/
+				tok NUM = /[0-9]+/
+				-> stmt = ID "=" value ";" | "print" value ";";
+				value = ID | NUM;
+			`},
+			constructs: []ConstructSets{
+				{Name: "stmt", First: []string{`"print"`, "ID"}, Follow: []string{"<EOF>"}},
+				{Name: "value", First: []string{"ID", "NUM"}, Follow: []string{`";"`}},
+			},
+			conflicts: []string{},
+		},
+		{
+			name: "common prefix",
+			files: map[string]string{"main.chisel": `
+				tok ID = /[a-z]+/
+				tok NUM = /[0-9]+/
+				-> stmt = ID "=" NUM ";" | ID "(" ")" ";";
+			`},
+			constructs: []ConstructSets{
+				{Name: "stmt", First: []string{"ID"}, Follow: []string{"<EOF>"}},
+			},
+			conflicts: []string{"alternatives stmt ID"},
+		},
+		{
+			name: "nullable tails",
+			files: map[string]string{"main.chisel": `
+				tok A = "a"
+				tok B = "b"
+				tok C = "c"
+				-> s = x A | C;
+				x = A y z;
+				y = B?;
+				z = (C | A B)?;
+			`},
+			constructs: []ConstructSets{
+				{Name: "s", First: []string{"A", "C"}, Follow: []string{"<EOF>"}},
+				{Name: "x", First: []string{"A"}, Follow: []string{"A"}},
+				{Name: "y", Nullable: true, First: []string{"B"}, Follow: []string{"A", "C"}},
+				{Name: "z", Nullable: true, First: []string{"A", "C"}, Follow: []string{"A"}},
+			},
+			conflicts: []string{"optional z A"},
+		},
+		{
+			name: "repetition into its follow",
+			files: map[string]string{"main.chisel": `
+				tok A = "a"
+				tok B = "b"
+				-> s = A* A B;
+			`},
+			constructs: []ConstructSets{
+				{Name: "s", First: []string{"A"}, Follow: []string{"<EOF>"}},
+			},
+			conflicts: []string{"repetition s A"},
+		},
+		{
+			name: "imports",
+			files: map[string]string{
+				"main.chisel": `
+					import "lib/items.chisel";
+					-> s = item+ ";";
+				`,
+				"lib/items.chisel": `
+					tok ID = /[a-z]+/
+					tok NUM = /[0-9]+/
+					item = ID | NUM | ID "." ID;
+				`,
+			},
+			constructs: []ConstructSets{
+				{Name: "item", First: []string{"ID", "NUM"}, Follow: []string{`";"`, "ID", "NUM"}},
+				{Name: "s", First: []string{"ID", "NUM"}, Follow: []string{"<EOF>"}},
+			},
+			conflicts: []string{"alternatives item ID"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, text := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(dir, "main.chisel")
+			r, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			analysis, err := Analyze(r, path)
+			if err != nil {
+				t.Fatalf("Analyze failed: %v", err)
+			}
+			if !reflect.DeepEqual(analysis.Constructs, tt.constructs) {
+				t.Errorf("constructs are\n%+v\nwant\n%+v", analysis.Constructs, tt.constructs)
+			}
+			conflicts := []string{}
+			for _, c := range analysis.Conflicts {
+				conflicts = append(conflicts, conflictSummary(c))
+			}
+			if !reflect.DeepEqual(conflicts, tt.conflicts) {
+				t.Errorf("conflicts are %q, want %q", conflicts, tt.conflicts)
+			}
+			if analysis.LL1() != (len(tt.conflicts) == 0) {
+				t.Errorf("LL1() is %v with %d conflict(s)", analysis.LL1(), len(tt.conflicts))
+			}
+		})
+	}
+}
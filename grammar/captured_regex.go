@@ -7,10 +7,11 @@ import (
 
 type CapturedRegex struct {
 	Inner Transpilable
+	Pos   Position
 }
 
 func (r *CapturedRegex) Name() string {
-	return r.Inner.Name()
+	return mangled(symbol(r.Inner))
 }
 
 func (r *CapturedRegex) Prototype() (string, error) {
@@ -21,7 +22,7 @@ func (r *CapturedRegex) Function() (string, error) {
 	return WriteString(
 		`
 		Result Lexer::capture{{.Name}}(std::vector<Node> &nodes) {
-			return {{.InnerCall}}
+			return {{.InnerCall}};
 		}
 		`,
 		map[string]any{
@@ -7,14 +7,15 @@ import (
 
 type ChainRegex struct {
 	Chain []Transpilable
+	Pos   Position
 }
 
 func (r *ChainRegex) Name() string {
 	s := []string{}
 	for _, t := range r.Chain {
-		s = append(s, t.Name())
+		s = append(s, symbol(t))
 	}
-	return strings.Join(s, "_")
+	return mangled(s...)
 }
 
 func (r *ChainRegex) Prototype() (string, error) {
@@ -22,16 +23,19 @@ func (r *ChainRegex) Prototype() (string, error) {
 }
 
 func (r *ChainRegex) Function() (string, error) {
+	// A failure rolls back what the earlier elements matched and goes back
+	// to the caller, which can try another alternative or recover from it.
 	s := []string{}
 	for _, t := range r.Chain {
 		s = append(s, "res = "+t.Call("nodes")+";")
-		s = append(s, "res.panic();")
+		s = append(s, "if (!res) {\n\t\t\t\trollback(start, nodes);\n\t\t\t\treturn res;\n\t\t\t}")
 	}
 
 	return WriteString(
 		`
 		Result Lexer::chain{{.Name}}(std::vector<Node> &nodes) {
-			auto {{.Innards}}
+			auto start = checkpoint(nodes);
+			Result {{.Innards}}
 			return Result();
 		}
 		`,
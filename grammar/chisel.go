@@ -1,23 +1,79 @@
 package grammar
 
 import (
+	"fmt"
 	"io"
+	"os"
 )
 
-func Chisel(r io.Reader, w io.Writer, chiselPath string, visitorWriter io.Writer) error {
-	readData, err := Read(r)
+// Options tunes the generated parser.
+type Options struct {
+	// Predictive makes every construct dispatch on the next token, as if
+	// the grammar had a `predictive;` directive.
+	Predictive bool
+	// Packrat memoizes every construct, as if the grammar had a `packrat;`
+	// directive.
+	Packrat bool
+	// Skip names the optimization passes not to run.
+	Skip map[string]bool
+	// DumpIR, if set, gets the constructs after Realize and after every
+	// optimization pass.
+	DumpIR io.Writer
+}
+
+// Chisel reads the grammar from r and writes the generated library to w,
+// returning how its functions were shared. grammarPath is used to label
+// positions in error messages.
+func Chisel(r io.Reader, grammarPath string, w io.Writer, chiselPath string, visitorWriter io.Writer, opts Options) (Stats, error) {
+	readData, constructs, err := load(r, grammarPath)
+	if err != nil {
+		return Stats{}, err
+	}
+	constructs = optimize(constructs, opts.Skip, opts.DumpIR)
+	recoverable(readData.Tokens, constructs)
+	predict(readData.Tokens, constructs, opts.Predictive || readData.Predictive)
+	memoize(constructs, opts.Packrat || readData.Packrat)
+	inlineCalls(constructs)
+
+	stats, err := Write(w, visitorWriter, chiselPath, readData.Tokens, constructs)
 	if err != nil && err != io.EOF {
-		return err
+		return Stats{}, err
 	}
+	return stats, nil
+}
 
-	constructs, err := Realize(&readData)
+// Analyze reads the grammar from r and computes its nullable, FIRST and FOLLOW
+// sets along with its LL(1) conflicts.
+func Analyze(r io.Reader, grammarPath string) (*Analysis, error) {
+	readData, constructs, err := load(r, grammarPath)
+	if err != nil {
+		return nil, err
+	}
+	return analyze(readData.Tokens, constructs), nil
+}
+
+// load runs a grammar through every step before code generation, printing
+// warnings to stderr.
+func load(r io.Reader, grammarPath string) (ReadData, []Construct, error) {
+	readData, err := Read(r, grammarPath)
 	if err != nil && err != io.EOF {
-		return err
+		return ReadData{}, nil, err
 	}
 
-	err = Write(w, visitorWriter, chiselPath, readData.Tokens, constructs)
+	diags := Expand(&readData)
+	if !diags.HasErrors() {
+		diags = append(diags, Validate(&readData)...)
+	}
+	for _, d := range diags.Filter(WARNING) {
+		fmt.Fprintln(os.Stderr, d.Error())
+	}
+	if diags.HasErrors() {
+		return ReadData{}, nil, diags.Filter(ERROR)
+	}
+
+	constructs, err := Realize(&readData)
 	if err != nil && err != io.EOF {
-		return err
+		return ReadData{}, nil, err
 	}
-	return nil
+	return readData, constructs, nil
 }
@@ -9,6 +9,15 @@ type Construct struct {
 	name       string
 	Value      Transpilable
 	EntryPoint bool
+	Predictive bool
+	Packrat    bool
+	// Inline has calls of the construct run its body in place, see
+	// optimize.go.
+	Inline bool
+	// Sync holds the tokens a construct recovers at, if it does.
+	Sync   []Token
+	Pos    Position
+	Fields []Field
 }
 
 func (c *Construct) Name() string {
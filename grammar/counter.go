@@ -1,9 +1,13 @@
 package grammar
 
-import "fmt"
+import "strings"
 
+// Counter collects the functions the lexer is made of. A function used in
+// several places, like the regex of a token or a sub-expression two
+// constructs share, is written once.
 type Counter struct {
-	tracker map[string]bool
+	// tracker counts the uses of every function by its symbol.
+	tracker map[string]int
 
 	tokenPrototypes []string
 	tokenFunctions  []string
@@ -15,18 +19,27 @@ type Counter struct {
 	constFunctions  []string
 }
 
+// Stats sums up what a Counter collected.
+type Stats struct {
+	// Functions is how many functions were written.
+	Functions int
+	// Uses is how many places use them.
+	Uses int
+	// Shared is how many functions are used in more than one place.
+	Shared int
+}
+
+func NewCounter() *Counter {
+	return &Counter{tracker: map[string]int{}}
+}
+
 func (c *Counter) Add(t Transpilable) error {
-	name := t.Name()
-	switch v := t.(type) {
-	case *Token:
-		name = fmt.Sprint("token", v.name)
-	}
-	if _, ok := c.tracker[name]; ok {
+	name := symbol(t)
+	c.tracker[name]++
+	if c.tracker[name] > 1 {
 		return nil
 	}
 
-	c.tracker[name] = true
-
 	var functions *[]string
 	var prototypes *[]string
 
@@ -55,3 +68,33 @@ func (c *Counter) Add(t Transpilable) error {
 	*functions = append(*functions, s)
 	return nil
 }
+
+// TokenData returns the prototypes and definitions of the token functions.
+func (c *Counter) TokenData() (string, string) {
+	return lines(c.tokenPrototypes), lines(c.tokenFunctions)
+}
+
+// RegexData returns the prototypes and definitions of the functions of
+// constructs and their regexes.
+func (c *Counter) RegexData() (string, string) {
+	return lines(c.regexPrototypes) + lines(c.constPrototypes), lines(c.regexFunctions) + lines(c.constFunctions)
+}
+
+func (c *Counter) Stats() Stats {
+	stats := Stats{Functions: len(c.tracker)}
+	for _, uses := range c.tracker {
+		stats.Uses += uses
+		if uses > 1 {
+			stats.Shared++
+		}
+	}
+	return stats
+}
+
+func lines(s []string) string {
+	var b strings.Builder
+	for _, line := range s {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
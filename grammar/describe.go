@@ -0,0 +1,92 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// describe renders a realized expression back in grammar syntax, for error
+// messages in the generated parser.
+func describe(t Transpilable) string {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return r.Token.DisplayName()
+	case *NestedRegex:
+		return r.Inner
+	case *ChainRegex:
+		s := make([]string, len(r.Chain))
+		for i, c := range r.Chain {
+			s[i] = describe(c)
+		}
+		return strings.Join(s, " ")
+	case *OrRegex:
+		s := make([]string, len(r.Chain))
+		for i, c := range r.Chain {
+			s[i] = describe(c)
+		}
+		return strings.Join(s, " | ")
+	case *CapturedRegex:
+		return "(" + describe(r.Inner) + ")"
+	case *MultiplierRegex:
+		if r.RequireOne {
+			return describe(r.Inner) + "+"
+		}
+		return describe(r.Inner) + "*"
+	case *OptionalRegex:
+		return describe(r.Inner) + "?"
+	case *RepeatRegex:
+		switch {
+		case r.Min == r.Max:
+			return fmt.Sprintf("%s{%d}", describe(r.Inner), r.Min)
+		case r.Max < 0:
+			return fmt.Sprintf("%s{%d,}", describe(r.Inner), r.Min)
+		default:
+			return fmt.Sprintf("%s{%d,%d}", describe(r.Inner), r.Min, r.Max)
+		}
+	case *SeparatedRegex:
+		if !r.Trailing && !r.Empty {
+			return describe(r.Item) + " % " + describe(r.Separator)
+		}
+		s := "sep(" + describe(r.Item) + ", " + describe(r.Separator)
+		if r.Trailing {
+			s += ", trailing"
+		}
+		if r.Empty {
+			s += ", empty"
+		}
+		return s + ")"
+	case *FieldRegex:
+		return r.Field + ":" + describe(r.Inner)
+	case *PredicateRegex:
+		if r.Negate {
+			return "!" + describe(r.Inner)
+		}
+		return "&" + describe(r.Inner)
+	case *SwitchRegex:
+		return describe(r.Or)
+	case *GuardedRegex:
+		switch {
+		case r.Min == 0 && r.Max == 1:
+			return describe(r.Inner) + "?"
+		case r.Min == 0 && r.Max < 0:
+			return describe(r.Inner) + "*"
+		case r.Min == 1 && r.Max < 0:
+			return describe(r.Inner) + "+"
+		}
+		return describe(&RepeatRegex{Inner: r.Inner, Min: r.Min, Max: r.Max})
+	case *LeftRecursiveRegex:
+		return describe(&OrRegex{Chain: r.Alternatives})
+	case *OperatorRegex:
+		levels := make([]string, len(r.Levels))
+		for i, l := range r.Levels {
+			ops := make([]string, len(l.Operators))
+			for j, op := range l.Operators {
+				ops[j] = describe(op)
+			}
+			levels[i] = fmt.Sprintf("%s %d: %s;", l.Fixity, l.Precedence, strings.Join(ops, " | "))
+		}
+		return "operators(" + describe(r.Operand) + ") { " + strings.Join(levels, " ") + " }"
+	default:
+		return t.Name()
+	}
+}
@@ -0,0 +1,507 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ * Regular expression tokens are compiled ahead of time:
+ *
+ * pattern -> regexp/syntax tree -> byte NFA (Thompson) -> DFA (subset
+ * construction) -> minimized DFA (partition refinement) -> C++ switch
+ *
+ * The generated lexer works on bytes, so literals, character classes and '.'
+ * match the UTF-8 encoding of their runes.
+ */
+
+type DFA struct {
+	// States[0] is the start state.
+	States []DFAState
+}
+
+type DFAState struct {
+	Accept bool
+	// Next holds the state to move to for every byte, -1 if there is none.
+	Next [256]int
+}
+
+func CompileRegex(pattern string) (*DFA, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &nfa{}
+	start, end := n.state(), n.state()
+	if err := n.build(re.Simplify(), start, end); err != nil {
+		return nil, err
+	}
+
+	d := n.determinize(start, end).minimize()
+	if d.States[0].Accept {
+		return nil, fmt.Errorf("pattern matches the empty string")
+	}
+	if len(d.States) == 1 {
+		return nil, fmt.Errorf("pattern can never match")
+	}
+	return d, nil
+}
+
+type nfaEdge struct {
+	lo, hi byte
+	to     int
+}
+
+type nfaState struct {
+	eps   []int
+	edges []nfaEdge
+}
+
+type nfa struct {
+	states []nfaState
+}
+
+func (n *nfa) state() int {
+	n.states = append(n.states, nfaState{})
+	return len(n.states) - 1
+}
+
+func (n *nfa) epsilon(from, to int) {
+	n.states[from].eps = append(n.states[from].eps, to)
+}
+
+func (n *nfa) edge(from int, lo, hi byte, to int) {
+	n.states[from].edges = append(n.states[from].edges, nfaEdge{lo: lo, hi: hi, to: to})
+}
+
+// build adds the states matching re between start and end.
+func (n *nfa) build(re *syntax.Regexp, start, end int) error {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return nil
+
+	case syntax.OpEmptyMatch:
+		n.epsilon(start, end)
+		return nil
+
+	case syntax.OpLiteral:
+		cur := start
+		for i, r := range re.Rune {
+			next := end
+			if i < len(re.Rune)-1 {
+				next = n.state()
+			}
+			n.rune(r, re.Flags&syntax.FoldCase != 0, cur, next)
+			cur = next
+		}
+		if len(re.Rune) == 0 {
+			n.epsilon(start, end)
+		}
+		return nil
+
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			n.runeRange(re.Rune[i], re.Rune[i+1], start, end)
+		}
+		return nil
+
+	case syntax.OpAnyCharNotNL:
+		n.runeRange(0, '\n'-1, start, end)
+		n.runeRange('\n'+1, unicode.MaxRune, start, end)
+		return nil
+
+	case syntax.OpAnyChar:
+		n.runeRange(0, unicode.MaxRune, start, end)
+		return nil
+
+	case syntax.OpCapture:
+		return n.build(re.Sub[0], start, end)
+
+	case syntax.OpConcat:
+		cur := start
+		for i, sub := range re.Sub {
+			next := end
+			if i < len(re.Sub)-1 {
+				next = n.state()
+			}
+			if err := n.build(sub, cur, next); err != nil {
+				return err
+			}
+			cur = next
+		}
+		if len(re.Sub) == 0 {
+			n.epsilon(start, end)
+		}
+		return nil
+
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if err := n.build(sub, start, end); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		// Route the loop through fresh states so it can't leak into
+		// alternatives sharing start or end.
+		in, out := n.state(), n.state()
+		n.epsilon(start, in)
+		n.epsilon(out, end)
+		if err := n.build(re.Sub[0], in, out); err != nil {
+			return err
+		}
+		if re.Op != syntax.OpPlus {
+			n.epsilon(in, out)
+		}
+		if re.Op != syntax.OpQuest {
+			n.epsilon(out, in)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("'%s' is not supported in token patterns", re)
+	}
+}
+
+func (n *nfa) rune(r rune, fold bool, start, end int) {
+	runes := []rune{r}
+	if fold {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			runes = append(runes, f)
+		}
+	}
+
+	for _, r := range runes {
+		var buf [utf8.UTFMax]byte
+		b := buf[:utf8.EncodeRune(buf[:], r)]
+		cur := start
+		for i := range b {
+			next := end
+			if i < len(b)-1 {
+				next = n.state()
+			}
+			n.edge(cur, b[i], b[i], next)
+			cur = next
+		}
+	}
+}
+
+// runeRange adds the byte sequences encoding the runes lo to hi. The range is
+// split until every byte of the encodings of its ends spans a single range,
+// the way RE2 compiles UTF-8. Surrogates have no encoding and are left out.
+func (n *nfa) runeRange(lo, hi rune, start, end int) {
+	if lo > hi {
+		return
+	}
+	if lo <= 0xDFFF && hi >= 0xD800 {
+		n.runeRange(lo, 0xD7FF, start, end)
+		n.runeRange(0xE000, hi, start, end)
+		return
+	}
+	// Both ends must take as many bytes.
+	for _, max := range []rune{0x7F, 0x7FF, 0xFFFF} {
+		if lo <= max && hi > max {
+			n.runeRange(lo, max, start, end)
+			n.runeRange(max+1, hi, start, end)
+			return
+		}
+	}
+	if hi <= 0x7F {
+		n.edge(start, byte(lo), byte(hi), end)
+		return
+	}
+	// Split off the runes that don't fill the continuation bytes.
+	for i := 1; i < utf8.UTFMax; i++ {
+		m := rune(1)<<(6*i) - 1
+		if lo&^m == hi&^m {
+			continue
+		}
+		if lo&m != 0 {
+			n.runeRange(lo, lo|m, start, end)
+			n.runeRange(lo|m+1, hi, start, end)
+			return
+		}
+		if hi&m != m {
+			n.runeRange(lo, hi&^m-1, start, end)
+			n.runeRange(hi&^m, hi, start, end)
+			return
+		}
+	}
+
+	var los, his [utf8.UTFMax]byte
+	l := utf8.EncodeRune(los[:], lo)
+	utf8.EncodeRune(his[:], hi)
+	cur := start
+	for i := 0; i < l; i++ {
+		next := end
+		if i < l-1 {
+			next = n.state()
+		}
+		n.edge(cur, los[i], his[i], next)
+		cur = next
+	}
+}
+
+func (n *nfa) closure(set []int) []int {
+	seen := map[int]bool{}
+	stack := append([]int{}, set...)
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		stack = append(stack, n.states[s].eps...)
+	}
+
+	res := make([]int, 0, len(seen))
+	for s := range seen {
+		res = append(res, s)
+	}
+	sort.Ints(res)
+	return res
+}
+
+func (n *nfa) determinize(start, end int) *DFA {
+	key := func(set []int) string {
+		return fmt.Sprint(set)
+	}
+
+	d := &DFA{}
+	ids := map[string]int{}
+	sets := [][]int{}
+	add := func(set []int) int {
+		k := key(set)
+		if id, ok := ids[k]; ok {
+			return id
+		}
+		id := len(d.States)
+		ids[k] = id
+		sets = append(sets, set)
+
+		st := DFAState{}
+		for _, s := range set {
+			if s == end {
+				st.Accept = true
+			}
+		}
+		for b := range st.Next {
+			st.Next[b] = -1
+		}
+		d.States = append(d.States, st)
+		return id
+	}
+
+	add(n.closure([]int{start}))
+	for i := 0; i < len(d.States); i++ {
+		var moves [256][]int
+		for _, s := range sets[i] {
+			for _, e := range n.states[s].edges {
+				for b := int(e.lo); b <= int(e.hi); b++ {
+					moves[b] = append(moves[b], e.to)
+				}
+			}
+		}
+		for b, targets := range moves {
+			if len(targets) == 0 {
+				continue
+			}
+			d.States[i].Next[b] = add(n.closure(targets))
+		}
+	}
+	return d
+}
+
+// minimize drops states that can never reach an accepting state and merges
+// equivalent ones. The start state stays at index 0.
+func (d *DFA) minimize() *DFA {
+	alive := make([]bool, len(d.States))
+	for changed := true; changed; {
+		changed = false
+		for i, s := range d.States {
+			if alive[i] {
+				continue
+			}
+			live := s.Accept
+			for _, next := range s.Next {
+				if next >= 0 && alive[next] {
+					live = true
+					break
+				}
+			}
+			if live {
+				alive[i] = true
+				changed = true
+			}
+		}
+	}
+
+	// Refine the accepting/non-accepting split until transitions agree.
+	// Dead states all share class -1, just like a missing transition.
+	class := make([]int, len(d.States))
+	classOf := func(s int) int {
+		if s < 0 || !alive[s] {
+			return -1
+		}
+		return class[s]
+	}
+	for i, s := range d.States {
+		if !alive[i] {
+			class[i] = -1
+		} else if s.Accept {
+			class[i] = 1
+		}
+	}
+
+	count := 0
+	for {
+		ids := map[string]int{}
+		next := make([]int, len(d.States))
+		for i, s := range d.States {
+			if !alive[i] {
+				next[i] = -1
+				continue
+			}
+			var sig strings.Builder
+			fmt.Fprint(&sig, class[i])
+			for _, t := range s.Next {
+				fmt.Fprint(&sig, ",", classOf(t))
+			}
+			id, ok := ids[sig.String()]
+			if !ok {
+				id = len(ids)
+				ids[sig.String()] = id
+			}
+			next[i] = id
+		}
+		class = next
+		if len(ids) == count {
+			break
+		}
+		count = len(ids)
+	}
+
+	// Renumber so the start state's class comes first.
+	order := map[int]int{}
+	queue := []int{0}
+	order[class[0]] = 0
+	reps := []int{0}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, t := range d.States[s].Next {
+			c := classOf(t)
+			if c < 0 {
+				continue
+			}
+			if _, ok := order[c]; !ok {
+				order[c] = len(reps)
+				reps = append(reps, t)
+				queue = append(queue, t)
+			}
+		}
+	}
+
+	res := &DFA{States: make([]DFAState, len(reps))}
+	for i, rep := range reps {
+		st := DFAState{Accept: d.States[rep].Accept}
+		for b, t := range d.States[rep].Next {
+			st.Next[b] = -1
+			if c := classOf(t); c >= 0 {
+				st.Next[b] = order[c]
+			}
+		}
+		res.States[i] = st
+	}
+	return res
+}
+
+type byteRange struct {
+	lo, hi byte
+	to     int
+}
+
+func (s *DFAState) ranges() []byteRange {
+	res := []byteRange{}
+	for b := 0; b < 256; b++ {
+		to := s.Next[b]
+		if to < 0 {
+			continue
+		}
+		if len(res) > 0 && res[len(res)-1].to == to && int(res[len(res)-1].hi) == b-1 {
+			res[len(res)-1].hi = byte(b)
+			continue
+		}
+		res = append(res, byteRange{lo: byte(b), hi: byte(b), to: to})
+	}
+	return res
+}
+
+// Matcher returns C++ statements that run the DFA over 'reader' and leave the
+// stream just past the longest match. Afterwards 'text' holds the accepted
+// bytes and 'accepted' their count, 0 if nothing matched. Patterns can't match
+// the empty string, so 0 is never a real match.
+func (d *DFA) Matcher() string {
+	var cases strings.Builder
+	accepting := make([]string, len(d.States))
+	for i, s := range d.States {
+		accepting[i] = fmt.Sprint(s.Accept)
+
+		ranges := s.ranges()
+		if len(ranges) == 0 {
+			continue
+		}
+		fmt.Fprintf(&cases, "\t\t\tcase %d:\n", i)
+		for j, r := range ranges {
+			cond := fmt.Sprintf("c == %d", r.lo)
+			if r.lo != r.hi {
+				cond = fmt.Sprintf("c >= %d && c <= %d", r.lo, r.hi)
+			}
+			keyword := "if"
+			if j > 0 {
+				keyword = "else if"
+			}
+			fmt.Fprintf(&cases, "\t\t\t\t%s (%s) next = %d;\n", keyword, cond, r.to)
+		}
+		cases.WriteString("\t\t\t\tbreak;\n")
+	}
+
+	s, _ := WriteString(
+		`static constexpr bool accepting[] = { {{.Accepting}} };
+		int state = 0;
+		std::string text;
+		std::string::size_type accepted = 0;
+		while (true) {
+			auto c = reader.peek();
+			if (c == std::char_traits<char>::eof())
+				break;
+
+			int next = -1;
+			switch (state) {
+{{.Cases}}			}
+			if (next < 0)
+				break;
+
+			reader.get();
+			text += static_cast<char>(c);
+			state = next;
+			if (accepting[state])
+				accepted = text.size();
+		}
+		if (text.size() > accepted)
+			reader.seekg(static_cast<std::streamoff>(accepted) - static_cast<std::streamoff>(text.size()), std::ios::cur);
+		text.resize(accepted);`,
+		map[string]any{
+			"Accepting": strings.Join(accepting, ", "),
+			"Cases":     cases.String(),
+		},
+	)
+	return s
+}
@@ -0,0 +1,148 @@
+package grammar
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"testing"
+)
+
+// longestMatch runs d over the start of input and returns the length of the
+// longest prefix it accepts, 0 if none.
+func longestMatch(d *DFA, input string) int {
+	state, accepted := 0, 0
+	for i := 0; i < len(input); i++ {
+		state = d.States[state].Next[input[i]]
+		if state < 0 {
+			break
+		}
+		if d.States[state].Accept {
+			accepted = i + 1
+		}
+	}
+	return accepted
+}
+
+// unminimized compiles pattern like CompileRegex, but stops before
+// minimizing.
+func unminimized(t *testing.T, pattern string) *DFA {
+	t.Helper()
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &nfa{}
+	start, end := n.state(), n.state()
+	if err := n.build(re.Simplify(), start, end); err != nil {
+		t.Fatal(err)
+	}
+	return n.determinize(start, end)
+}
+
+var dfaInputs = []string{
+	"", "a", "abc", "abcabc", "aaaa", "ab1", "x_9 y", "Zz", "0", "123.45", "1.", ".5",
+	"\n", "a\nb", "\t ", "\"quoted\" rest", "\"open", "é", "éàx", "αβγ!", "a€b",
+	"😀😀", "ĀĀ", "日本語", "ab\\n", "a.b", "a+b", "(x)", "[]", "-", "--", "aaaaaaa",
+}
+
+func TestCompileRegexMatchesRegexp(t *testing.T) {
+	patterns := []string{
+		// Literals and escapes.
+		`abc`, `a\.b`, `a\+b`, `\(x\)`, `\[\]`, `\n`, `\t`, `\\n`, `"[^"]*"`,
+		// Classes and negated classes.
+		`[a-z]+`, `[a-zA-Z_][a-zA-Z0-9_]*`, `[^a-z]+`, `[^\n]+`, `\d+`, `\w+`, `\s+`,
+		`[éà]+`, `[a-zα-ω]+`, `[^a]+`, `[\x{100}-\x{10FFFF}]+`, `[\x{7F}-\x{800}]`,
+		`(?i)[ä]+`, `(?i)abc`, `[[:alpha:]]+`, `\pL+`,
+		// Any character.
+		`.`, `.+`, `a.b`, `(?s).+`,
+		// Multibyte literals.
+		`é+`, `日本`, `😀+`, `a€b`,
+		// Repeats and alternation.
+		`a*b`, `a+`, `a?b`, `a{2}`, `a{2,}`, `a{1,3}`, `(ab){1,2}`, `[0-9]+(\.[0-9]+)?`,
+		`ab|abc`, `a|b|c`, `(a|b)*c`, `-|--`,
+	}
+	for _, pattern := range patterns {
+		d, err := CompileRegex(pattern)
+		if err != nil {
+			t.Errorf("%s: %v", pattern, err)
+			continue
+		}
+		re := regexp.MustCompile(`^(?:` + pattern + `)`)
+		re.Longest()
+		full := unminimized(t, pattern)
+		for _, input := range dfaInputs {
+			want := 0
+			if loc := re.FindStringIndex(input); loc != nil {
+				want = loc[1]
+			}
+			if got := longestMatch(d, input); got != want {
+				t.Errorf("%s on %q: matched %d bytes, regexp matches %d", pattern, input, got, want)
+			}
+			if got := longestMatch(full, input); got != want {
+				t.Errorf("%s on %q: before minimizing matched %d bytes, regexp matches %d", pattern, input, got, want)
+			}
+		}
+	}
+}
+
+func TestCompileRegexInvalidUTF8(t *testing.T) {
+	for _, pattern := range []string{`.`, `[^a]`, `[\x{80}-\x{10FFFF}]`} {
+		d, err := CompileRegex(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range []string{"\xe9", "\xff", "\xc3", "\xed\xa0\x80"} {
+			if got := longestMatch(d, input); got != 0 {
+				t.Errorf("%s matches %d bytes of invalid UTF-8 %q", pattern, got, input)
+			}
+		}
+	}
+}
+
+func TestMinimizeKeepsLanguage(t *testing.T) {
+	for _, pattern := range []string{`(a|b)*abb`, `[a-z]+|[a-z]+[0-9]`, `(ab|ab)+`, `a*a*a*`, `[éà]+|é`, `x(y|z)*|xy*`} {
+		full := unminimized(t, pattern)
+		min := full.minimize()
+		if len(min.States) > len(full.States) {
+			t.Errorf("%s: minimizing grew %d states to %d", pattern, len(full.States), len(min.States))
+		}
+
+		// Every string over the bytes the pattern mentions, up to length 4.
+		alphabet := "abxyz09é"
+		inputs := []string{""}
+		for length := 0; length < 4; length++ {
+			next := []string{}
+			for _, in := range inputs {
+				for _, c := range strings.Split(alphabet, "") {
+					next = append(next, in+c)
+				}
+			}
+			inputs = append(inputs, next...)
+		}
+		for _, input := range inputs {
+			if a, b := longestMatch(full, input), longestMatch(min, input); a != b {
+				t.Errorf("%s on %q: matched %d bytes before minimizing, %d after", pattern, input, a, b)
+			}
+		}
+	}
+}
+
+func TestCompileRegexErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`a*`, "pattern matches the empty string"},
+		{`^a`, "not supported"},
+		{`a$`, "not supported"},
+		{`\ba`, "not supported"},
+		{`[a-`, "missing closing ]"},
+		{`[^\x00-\x{10FFFF}]`, "pattern can never match"},
+	}
+	for _, tt := range tests {
+		_, err := CompileRegex(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.pattern, err, tt.want)
+		}
+	}
+}
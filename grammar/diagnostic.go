@@ -0,0 +1,98 @@
+package grammar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	ERROR Severity = iota
+	WARNING
+)
+
+func (s Severity) String() string {
+	switch s {
+	case ERROR:
+		return "error"
+	case WARNING:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a single problem found in a grammar.
+type Diagnostic struct {
+	Severity Severity
+	Pos      Position
+	Msg      string
+}
+
+func (d Diagnostic) Error() string {
+	s := fmt.Sprintf("%s: %s", d.Severity, d.Msg)
+	if d.Pos.IsValid() {
+		s = fmt.Sprintf("%s: %s", d.Pos, s)
+	} else if d.Pos.File != "" {
+		s = fmt.Sprintf("%s: %s", d.Pos.File, s)
+	}
+	if snippet := d.Pos.Snippet(); snippet != "" {
+		s += "\n" + snippet
+	}
+	return s
+}
+
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	s := make([]string, len(ds))
+	for i, d := range ds {
+		s[i] = d.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+func (ds *Diagnostics) add(severity Severity, pos Position, format string, args ...any) {
+	*ds = append(*ds, Diagnostic{
+		Severity: severity,
+		Pos:      pos,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// addError records a grammar error as an error diagnostic.
+func (ds *Diagnostics) addError(err error) {
+	if e, ok := err.(*GrammarError); ok {
+		ds.add(ERROR, e.Pos, "%s", e.Msg)
+		return
+	}
+	ds.add(ERROR, Position{}, "%s", err.Error())
+}
+
+// Filter returns the diagnostics of the given severity.
+func (ds Diagnostics) Filter(severity Severity) Diagnostics {
+	res := Diagnostics{}
+	for _, d := range ds {
+		if d.Severity == severity {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// Sort orders the diagnostics by where they occur in the grammar.
+func (ds Diagnostics) Sort() {
+	sort.SliceStable(ds, func(i, j int) bool {
+		a, b := ds[i].Pos, ds[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	return len(ds.Filter(ERROR)) > 0
+}
@@ -0,0 +1,30 @@
+package grammar
+
+import "testing"
+
+func TestErrorFormats(t *testing.T) {
+	source := NewSource("g.chisel", []byte("-> p = A B;\n"))
+	pos := source.position(9)
+	tests := []struct {
+		name string
+		pos  Position
+		want string
+	}{
+		{"positioned", pos, "g.chisel:1:10: error: 'B' not found!\n-> p = A B;\n         ^"},
+		{"file only", Position{File: "g.chisel"}, "g.chisel: error: 'B' not found!"},
+		{"nowhere", Position{}, "error: 'B' not found!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grammarError := errorAt(tt.pos, "'%s' not found!", "B").Error()
+			diags := Diagnostics{}
+			diags.add(ERROR, tt.pos, "'%s' not found!", "B")
+			if grammarError != tt.want {
+				t.Errorf("GrammarError renders\n%s\nwant\n%s", grammarError, tt.want)
+			}
+			if diags.Error() != tt.want {
+				t.Errorf("Diagnostic renders\n%s\nwant\n%s", diags.Error(), tt.want)
+			}
+		})
+	}
+}
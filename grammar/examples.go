@@ -0,0 +1,177 @@
+package grammar
+
+// maxExampleTokens is how much of an example input conflict reports show.
+const maxExampleTokens = 6
+
+// shortestSentences finds a shortest token sequence every construct matches.
+// Constructs that can never finish matching are left out.
+func shortestSentences(constructs []Construct) map[string][]string {
+	res := map[string][]string{}
+	for changed := true; changed; {
+		changed = false
+		for _, c := range constructs {
+			s, ok := shortestOf(c.Value, res)
+			if !ok {
+				continue
+			}
+			if prev, seen := res[c.Name()]; !seen || len(s) < len(prev) {
+				res[c.Name()] = s
+				changed = true
+			}
+		}
+	}
+	return res
+}
+
+// shortestOf is a shortest token sequence t matches, given the shortest
+// sentences of the constructs found so far.
+func shortestOf(t Transpilable, constructs map[string][]string) ([]string, bool) {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return []string{r.Name()}, true
+	case *NestedRegex:
+		s, ok := constructs[r.Inner]
+		return s, ok
+	case *PredicateRegex, *OptionalRegex:
+		return []string{}, true
+	case *FieldRegex:
+		return shortestOf(r.Inner, constructs)
+	case *CapturedRegex:
+		return shortestOf(r.Inner, constructs)
+	case *MultiplierRegex:
+		if !r.RequireOne {
+			return []string{}, true
+		}
+		return shortestOf(r.Inner, constructs)
+	case *RepeatRegex:
+		s, ok := shortestOf(r.Inner, constructs)
+		res := []string{}
+		for i := 0; i < r.Min; i++ {
+			res = append(res, s...)
+		}
+		return res, ok || r.Min == 0
+	case *SeparatedRegex:
+		if r.Empty {
+			return []string{}, true
+		}
+		return shortestOf(r.Item, constructs)
+	case *OperatorRegex:
+		return shortestOf(r.Operand, constructs)
+	case *LeftRecursiveRegex:
+		return shortestOf(r.Base, constructs)
+	case *ChainRegex:
+		return shortestChain(r.Chain, constructs)
+	case *OrRegex:
+		var best []string
+		found := false
+		for _, c := range r.Chain {
+			if s, ok := shortestOf(c, constructs); ok && (!found || len(s) < len(best)) {
+				best, found = s, true
+			}
+		}
+		return best, found
+	default:
+		return nil, false
+	}
+}
+
+func shortestChain(chain []Transpilable, constructs map[string][]string) ([]string, bool) {
+	res := []string{}
+	for _, c := range chain {
+		s, ok := shortestOf(c, constructs)
+		if !ok {
+			return nil, false
+		}
+		res = append(res, s...)
+	}
+	return res, true
+}
+
+// example renders a short input t matches that starts with tok, or nothing if
+// t can only get to tok by matching nothing first.
+func (a *analyzer) example(t Transpilable, tok string) []string {
+	s, ok := a.startingWith(t, tok, map[string]bool{})
+	if !ok {
+		return []string{}
+	}
+	res := []string{}
+	for i, name := range s {
+		if i == maxExampleTokens {
+			res = append(res, "...")
+			break
+		}
+		res = append(res, a.display[name])
+	}
+	return res
+}
+
+// startingWith is a shortest token sequence t matches that starts with tok.
+// visiting guards against constructs that call themselves on the left.
+func (a *analyzer) startingWith(t Transpilable, tok string, visiting map[string]bool) ([]string, bool) {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return []string{tok}, r.Name() == tok
+	case *NestedRegex:
+		c, ok := a.constructs[r.Inner]
+		if !ok || visiting[r.Inner] {
+			return nil, false
+		}
+		visiting[r.Inner] = true
+		defer delete(visiting, r.Inner)
+		return a.startingWith(c.Value, tok, visiting)
+	case *FieldRegex:
+		return a.startingWith(r.Inner, tok, visiting)
+	case *CapturedRegex:
+		return a.startingWith(r.Inner, tok, visiting)
+	case *OptionalRegex:
+		return a.startingWith(r.Inner, tok, visiting)
+	case *MultiplierRegex:
+		return a.startingWith(r.Inner, tok, visiting)
+	case *RepeatRegex:
+		s, ok := a.startingWith(r.Inner, tok, visiting)
+		if !ok {
+			return nil, false
+		}
+		rest, _ := shortestOf(r.Inner, a.shortest)
+		for i := 1; i < r.Min; i++ {
+			s = append(s, rest...)
+		}
+		return s, true
+	case *SeparatedRegex:
+		return a.startingWith(r.Item, tok, visiting)
+	case *OperatorRegex:
+		for _, l := range r.Levels {
+			for _, op := range l.Operators {
+				if l.Fixity == PREFIX_OPERATOR && op.Name() == tok {
+					rest, _ := shortestOf(r.Operand, a.shortest)
+					return append([]string{tok}, rest...), true
+				}
+			}
+		}
+		return a.startingWith(r.Operand, tok, visiting)
+	case *LeftRecursiveRegex:
+		return a.startingWith(r.Base, tok, visiting)
+	case *ChainRegex:
+		for i, c := range r.Chain {
+			if s, ok := a.startingWith(c, tok, visiting); ok {
+				rest, _ := shortestChain(r.Chain[i+1:], a.shortest)
+				return append(s, rest...), true
+			}
+			if !nullable(c, a.nullable) {
+				break
+			}
+		}
+		return nil, false
+	case *OrRegex:
+		var best []string
+		found := false
+		for _, c := range r.Chain {
+			if s, ok := a.startingWith(c, tok, visiting); ok && (!found || len(s) < len(best)) {
+				best, found = s, true
+			}
+		}
+		return best, found
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,255 @@
+package grammar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldRegex labels the nodes its inner regex produces so they can be looked
+// up by name instead of by position: callee:ident
+type FieldRegex struct {
+	Field string
+	Inner Transpilable
+	Pos   Position
+}
+
+func (r *FieldRegex) Name() string {
+	return mangled(r.Field, symbol(r.Inner))
+}
+
+func (r *FieldRegex) Prototype() (string, error) {
+	return WriteString("Result field{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+func (r *FieldRegex) Function() (string, error) {
+	return WriteString(
+		`
+		Result Lexer::field{{.Name}}(std::vector<Node> &nodes) {
+			auto begin = nodes.size();
+			auto res = {{.InnerCall}};
+			if (res)
+				current->mark(ParseNode::Field::{{.Field}}, begin, nodes.size());
+			return res;
+		}
+		`,
+		map[string]any{
+			"Name":      r.Name(),
+			"Field":     r.Field,
+			"InnerCall": r.Inner.Call("nodes"),
+		},
+	)
+}
+
+func (r *FieldRegex) Call(args ...string) string {
+	return fmt.Sprintf("field%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *FieldRegex) Accumulate() []Transpilable {
+	return append([]Transpilable{r}, r.Inner.Accumulate()...)
+}
+
+type FieldKind int
+
+const (
+	// SINGLE_FIELD always holds exactly one node.
+	SINGLE_FIELD FieldKind = iota
+	// OPTIONAL_FIELD holds at most one node.
+	OPTIONAL_FIELD
+	// LIST_FIELD holds any number of nodes.
+	LIST_FIELD
+)
+
+type Field struct {
+	Name string
+	Kind FieldKind
+	Pos  Position
+}
+
+// bounds is how many nodes something produces, max -1 meaning unbounded.
+type bounds struct {
+	min, max int
+}
+
+func (b bounds) add(o bounds) bounds {
+	if b.max < 0 || o.max < 0 {
+		return bounds{b.min + o.min, -1}
+	}
+	return bounds{b.min + o.min, b.max + o.max}
+}
+
+// repeat is b matched between min and max times, max -1 meaning unbounded.
+func (b bounds) repeat(min, max int) bounds {
+	res := bounds{b.min * min, b.max * max}
+	if b.max < 0 || max < 0 {
+		res.max = -1
+	}
+	if b.max == 0 {
+		res.max = 0
+	}
+	return res
+}
+
+func (b bounds) union(o bounds) bounds {
+	res := bounds{min(b.min, o.min), max(b.max, o.max)}
+	if b.max < 0 || o.max < 0 {
+		res.max = -1
+	}
+	return res
+}
+
+// nodeBounds is how many nodes t pushes onto its node list.
+func nodeBounds(t Transpilable) bounds {
+	switch r := t.(type) {
+	case *TokenRegex, *NestedRegex, *OperatorRegex:
+		return bounds{1, 1}
+	case *PredicateRegex:
+		return bounds{0, 0}
+	case *FieldRegex:
+		return nodeBounds(r.Inner)
+	case *LeftRecursiveRegex:
+		return nodeBounds(&OrRegex{Chain: r.Alternatives})
+	case *CapturedRegex:
+		return nodeBounds(r.Inner)
+	case *OptionalRegex:
+		return bounds{0, nodeBounds(r.Inner).max}
+	case *SeparatedRegex:
+		b := nodeBounds(r.Item).repeat(1, -1)
+		if r.Empty {
+			b.min = 0
+		}
+		return b
+	case *RepeatRegex:
+		return nodeBounds(r.Inner).repeat(r.Min, r.Max)
+	case *MultiplierRegex:
+		b := nodeBounds(r.Inner)
+		if !r.RequireOne {
+			b.min = 0
+		}
+		if b.max != 0 {
+			b.max = -1
+		}
+		return b
+	case *ChainRegex:
+		res := bounds{}
+		for _, c := range r.Chain {
+			res = res.add(nodeBounds(c))
+		}
+		return res
+	case *OrRegex:
+		res := nodeBounds(r.Chain[0])
+		for _, c := range r.Chain[1:] {
+			res = res.union(nodeBounds(c))
+		}
+		return res
+	default:
+		return bounds{0, -1}
+	}
+}
+
+// fieldBounds is how many nodes every field inside t gets labeled with.
+func fieldBounds(t Transpilable) map[string]bounds {
+	switch r := t.(type) {
+	case *FieldRegex:
+		res := fieldBounds(r.Inner)
+		res[r.Field] = res[r.Field].add(nodeBounds(r.Inner))
+		return res
+	case *CapturedRegex:
+		return fieldBounds(r.Inner)
+	case *LeftRecursiveRegex:
+		return fieldBounds(&OrRegex{Chain: r.Alternatives})
+	case *OptionalRegex:
+		res := fieldBounds(r.Inner)
+		for f, b := range res {
+			res[f] = bounds{0, b.max}
+		}
+		return res
+	case *RepeatRegex:
+		res := fieldBounds(r.Inner)
+		for f, b := range res {
+			res[f] = b.repeat(r.Min, r.Max)
+		}
+		return res
+	case *SeparatedRegex:
+		// Separators never reach the node list, so only the item counts.
+		res := fieldBounds(r.Item)
+		for f, b := range res {
+			b = b.repeat(1, -1)
+			if r.Empty {
+				b.min = 0
+			}
+			res[f] = b
+		}
+		return res
+	case *MultiplierRegex:
+		res := fieldBounds(r.Inner)
+		for f, b := range res {
+			if !r.RequireOne {
+				b.min = 0
+			}
+			if b.max != 0 {
+				b.max = -1
+			}
+			res[f] = b
+		}
+		return res
+	case *ChainRegex:
+		res := map[string]bounds{}
+		for _, c := range r.Chain {
+			for f, b := range fieldBounds(c) {
+				res[f] = res[f].add(b)
+			}
+		}
+		return res
+	case *OrRegex:
+		alts := make([]map[string]bounds, len(r.Chain))
+		res := map[string]bounds{}
+		for i, c := range r.Chain {
+			alts[i] = fieldBounds(c)
+			for f := range alts[i] {
+				res[f] = bounds{}
+			}
+		}
+		for f := range res {
+			b := alts[0][f]
+			for _, alt := range alts[1:] {
+				b = b.union(alt[f])
+			}
+			res[f] = b
+		}
+		return res
+	default:
+		return map[string]bounds{}
+	}
+}
+
+// fieldsOf lists the fields labeled inside t in the order they're first used.
+func fieldsOf(t Transpilable) []Field {
+	positions := map[string]Position{}
+	for _, a := range t.Accumulate() {
+		if f, ok := a.(*FieldRegex); ok {
+			if _, seen := positions[f.Field]; !seen {
+				positions[f.Field] = f.Pos
+			}
+		}
+	}
+
+	fields := []Field{}
+	for name, b := range fieldBounds(t) {
+		kind := LIST_FIELD
+		if b.min == 1 && b.max == 1 {
+			kind = SINGLE_FIELD
+		} else if b.max >= 0 && b.max <= 1 {
+			kind = OPTIONAL_FIELD
+		}
+		fields = append(fields, Field{
+			Name: name,
+			Kind: kind,
+			Pos:  positions[name],
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Pos.Offset < fields[j].Pos.Offset
+	})
+	return fields
+}
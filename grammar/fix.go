@@ -1,7 +1,6 @@
 package grammar
 
 import (
-	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -21,22 +20,29 @@ func readFix(t GrammarTokenType, ts string, gr *GrammarReader) (string, error) {
 		return "", err
 	}
 	if tok.Type != t {
-		return "", fmt.Errorf("Expected '%s', found %s!", ts, strconv.Quote(tok.Value))
+		return "", errorAt(tok.Pos, "Expected '%s', found %s!", ts, strconv.Quote(tok.Value))
 	}
 
-	if tok, err = gr.Read(); err != nil {
+	if tok, err = gr.ReadExpecting("'{'"); err != nil {
 		return "", err
 	}
 	if tok.Type != O_BRACE {
-		return "", fmt.Errorf("'%s' must be followed by an open curly brace '{' with a matching '}' at the end! Got %s", ts, strconv.Quote(tok.Value))
+		return "", errorAt(tok.Pos, "'%s' must be followed by an open curly brace '{' with a matching '}' at the end! Got %s", ts, strconv.Quote(tok.Value))
 	}
+	return readFixBody(ts, gr.reader, tok.Pos)
+}
 
-	r := gr.reader
+// readFixBody reads the body of a prefix or suffix after its '{', up to the
+// matching '}'. Braces in strings and comments don't count.
+func readFixBody(ts string, r *SourceReader, open Position) (string, error) {
 	var s strings.Builder
 	count := 1
 
 	for {
 		b, err := r.ReadByte()
+		if err == io.EOF {
+			return "", errorAt(open, "Unterminated '%s' body, expected a matching '}'!", ts)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -47,6 +53,9 @@ func readFix(t GrammarTokenType, ts string, gr *GrammarReader) (string, error) {
 			quote := b
 			for {
 				c, err := r.ReadByte()
+				if err == io.EOF {
+					return "", errorAt(open, "Unterminated string literal in '%s' body!", ts)
+				}
 				if err != nil {
 					return "", err
 				}
@@ -54,6 +63,9 @@ func readFix(t GrammarTokenType, ts string, gr *GrammarReader) (string, error) {
 				if c == '\\' {
 					// escape next char
 					next, err := r.ReadByte()
+					if err == io.EOF {
+						return "", errorAt(open, "Unterminated string literal in '%s' body!", ts)
+					}
 					if err != nil {
 						return "", err
 					}
@@ -94,6 +106,7 @@ func readFix(t GrammarTokenType, ts string, gr *GrammarReader) (string, error) {
 				}
 				continue
 			} else if n[0] == '*' {
+				start := r.Pos()
 				// consume the '*'
 				r.ReadByte()
 				// skip until "*/"
@@ -101,7 +114,7 @@ func readFix(t GrammarTokenType, ts string, gr *GrammarReader) (string, error) {
 				for {
 					c, err := r.ReadByte()
 					if err != nil {
-						return "", fmt.Errorf("unterminated block comment")
+						return "", errorAt(start, "Unterminated block comment!")
 					}
 					if prev == '*' && c == '/' {
 						break
@@ -0,0 +1,434 @@
+package grammar
+
+import (
+	"io"
+	"strings"
+)
+
+/*
+ * `chisel fmt` rewrites a grammar in one style: one declaration per line with
+ * the '=' of neighbouring tokens and constructs aligned, single spaces around
+ * '|', '%' and '=', none inside groups, after labels or before suffixes, and
+ * constructs too long for a line broken before each top level '|':
+ *
+ * stmt = "if" cond:expr block
+ *	| "while" cond:expr block
+ *	| expr ";";
+ *
+ * Comments stay where they are, blank lines between declarations are kept
+ * (one at most), and strings, patterns, code blocks and prefix and suffix
+ * bodies are copied as written.
+ */
+
+// formatWidth is how long a construct can get before its alternatives go on
+// lines of their own.
+const formatWidth = 80
+
+// formatToken is a grammar token as written in the file.
+type formatToken struct {
+	GrammarToken
+	Text string
+}
+
+// end is the line the token ends on.
+func (t formatToken) end() int {
+	return t.Pos.Line + strings.Count(t.Text, "\n")
+}
+
+func commentEnd(c Comment) int {
+	return c.Pos.Line + strings.Count(c.Text, "\n")
+}
+
+type formatKind int
+
+const (
+	FORMAT_TOKEN formatKind = iota
+	FORMAT_CONSTRUCT
+	FORMAT_OTHER
+)
+
+// formatItem is one top level declaration.
+type formatItem struct {
+	Kind formatKind
+	Toks []formatToken
+	// Left and Right are the rendered sides of the '=' of tokens and
+	// constructs, Right ending in whatever closes the declaration. Other
+	// declarations only have Left.
+	Left  string
+	Right string
+}
+
+// Format rewrites a grammar file in the canonical style. file labels
+// positions in errors.
+func Format(text []byte, file string) ([]byte, error) {
+	toks, trailing, err := readFormatTokens(NewSource(file, text))
+	if err != nil {
+		return nil, err
+	}
+	items, trailing := splitFormatItems(toks, trailing)
+	for i := range items {
+		items[i].render()
+	}
+	return layout(items, trailing), nil
+}
+
+// readFormatTokens reads every token of a source along with its spelling,
+// and the comments after the last one. A prefix or suffix body becomes a
+// single CPP_CODE token, braces included.
+func readFormatTokens(source *Source) ([]formatToken, []Comment, error) {
+	r := NewSourceReader(source)
+	gr := NewGrammarReader(r)
+	toks := []formatToken{}
+	for {
+		tok, err := gr.Read()
+		if err == io.EOF {
+			return toks, r.comments, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		n := len(toks)
+		if tok.Type == O_BRACE && n > 0 && (toks[n-1].Type == PREFIX || toks[n-1].Type == SUFFIX) {
+			if _, err := readFixBody(toks[n-1].Value, r, tok.Pos); err != nil {
+				return nil, nil, err
+			}
+			tok.Type = CPP_CODE
+		}
+		toks = append(toks, formatToken{
+			GrammarToken: tok,
+			Text:         string(source.Text[tok.Pos.Offset:r.offset]),
+		})
+	}
+}
+
+// splitFormatItems groups tokens into declarations. Stray semicolons are
+// dropped, their comments moving on to whatever comes next.
+func splitFormatItems(toks []formatToken, trailing []Comment) ([]formatItem, []Comment) {
+	items := []formatItem{}
+	carried := []Comment{}
+	through := func(i int, types ...GrammarTokenType) int {
+		for _, t := range types {
+			if i < len(toks) && toks[i].Type == t {
+				i++
+			}
+		}
+		return i
+	}
+	untilSemiColon := func(i int) int {
+		depth := 0
+		for ; i < len(toks); i++ {
+			switch toks[i].Type {
+			case O_BRACE:
+				depth++
+			case C_BRACE:
+				depth--
+			case SEMI_COLON:
+				if depth <= 0 {
+					return i + 1
+				}
+			}
+		}
+		return i
+	}
+
+	// keyword types toks[i] as the keyword it is at the start of a
+	// declaration, if any.
+	keyword := func(i int) GrammarTokenType {
+		next := GrammarToken{}
+		if i+1 < len(toks) {
+			next = toks[i+1].GrammarToken
+		}
+		toks[i].Type = declarationKeyword(toks[i].GrammarToken, next)
+		return toks[i].Type
+	}
+
+	for i := 0; i < len(toks); {
+		toks[i].Comments = append(carried, toks[i].Comments...)
+		carried = []Comment{}
+
+		kind := FORMAT_OTHER
+		var j int
+		switch keyword(i) {
+		case SEMI_COLON:
+			carried = toks[i].Comments
+			i++
+			continue
+		case IMPORT, RECOVER:
+			j = untilSemiColon(i)
+		case PREFIX, SUFFIX:
+			j = through(i+1, CPP_CODE)
+		case TOK, SKIP:
+			kind = FORMAT_TOKEN
+			j = through(i+1, INT, ID, EQ)
+			if j < len(toks) && toks[j-1].Type == EQ {
+				j = through(j+1, SEMI_COLON)
+			}
+		default:
+			kind = FORMAT_CONSTRUCT
+			k := i
+			for k < len(toks) && (keyword(k) == PREDICTIVE || keyword(k) == PACKRAT) {
+				k++
+			}
+			if k > i && k < len(toks) && toks[k].Type == SEMI_COLON {
+				kind = FORMAT_OTHER
+			}
+			j = untilSemiColon(i)
+		}
+		if j <= i {
+			j = i + 1
+		}
+		items = append(items, formatItem{Kind: kind, Toks: toks[i:j]})
+		i = j
+	}
+	return items, append(carried, trailing...)
+}
+
+// render lays out the item, apart from the comments before it.
+func (it *formatItem) render() {
+	toks := append([]formatToken{}, it.Toks...)
+	toks[0].Comments = nil
+
+	eq := -1
+	if it.Kind != FORMAT_OTHER {
+		for i, t := range toks {
+			if t.Type == EQ {
+				eq = i
+				break
+			}
+		}
+	}
+	if eq <= 0 {
+		it.Kind = FORMAT_OTHER
+		it.Left = renderTokens(toks, 0, false)
+		return
+	}
+
+	left := toks[:eq]
+	right := append([]formatToken{}, toks[eq+1:]...)
+	if it.Kind == FORMAT_CONSTRUCT && (len(right) == 0 || right[len(right)-1].Type != SEMI_COLON) {
+		right = append(right, formatToken{GrammarToken: GrammarToken{Type: SEMI_COLON, Value: ";"}, Text: ";"})
+	}
+	if len(right) > 0 {
+		right[0].Comments = append(toks[eq].Comments, right[0].Comments...)
+	}
+
+	it.Left = renderTokens(left, 0, false)
+	line := toks[eq].end()
+	it.Right = renderTokens(right, line, false)
+	if it.Kind == FORMAT_CONSTRUCT && len(it.Left)+len(" = ")+len(it.Right) > formatWidth && alternatives(right) {
+		it.Right = renderTokens(right, line, true)
+	}
+}
+
+// alternatives tells whether a body has a '|' outside of groups, so it can be
+// broken into one alternative per line.
+func alternatives(toks []formatToken) bool {
+	depth := 0
+	for _, t := range toks {
+		switch t.Type {
+		case O_PAREN, O_ANGLE, O_BRACE:
+			depth++
+		case C_PAREN, C_ANGLE, C_BRACE:
+			depth--
+		case PIPE:
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderTokens joins tokens with canonical spacing. line is the line the
+// text before them ends on, so comments on it stay at its end. With breakAlts
+// every top level '|' starts a new line.
+func renderTokens(toks []formatToken, line int, breakAlts bool) string {
+	var b strings.Builder
+	atStart := false
+	pending := false
+	newline := func() {
+		b.WriteByte('\n')
+		atStart = true
+	}
+
+	depth := 0
+	repeat := false
+	table := false
+	for i, t := range toks {
+		// Comments on the line of the previous token stay at its end, the
+		// others get lines of their own.
+		afterComment := false
+		for _, c := range t.Comments {
+			if c.Pos.Line == line && !atStart {
+				if b.Len() > 0 {
+					b.WriteByte(' ')
+				}
+				b.WriteString(c.Text)
+				pending = pending || c.line()
+			} else {
+				if !atStart {
+					newline()
+				}
+				b.WriteString("\t" + c.Text)
+				atStart = false
+				pending = true
+			}
+			afterComment = true
+			line = commentEnd(c)
+		}
+
+		closing := table && t.Type == C_BRACE
+		if breakAlts && t.Type == PIPE && depth == 0 && !table {
+			pending = true
+		}
+		if (pending || closing) && b.Len() > 0 && !atStart {
+			newline()
+		}
+
+		space := i > 0 || afterComment
+		if i > 0 && !afterComment {
+			space = spaced(toks[i-1], t, repeat, table)
+		}
+		if t.Type == O_BRACE && i+1 < len(toks) && toks[i+1].Type == INT {
+			repeat = true
+			space = false
+		}
+		switch {
+		case atStart && closing:
+			atStart = false
+		case atStart:
+			b.WriteByte('\t')
+			atStart = false
+		case space:
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.Text)
+		line = t.end()
+		pending = false
+
+		switch t.Type {
+		case O_PAREN, O_ANGLE:
+			depth++
+		case C_PAREN, C_ANGLE:
+			depth--
+		case O_BRACE:
+			if !repeat {
+				table = true
+				pending = true
+			}
+		case C_BRACE:
+			if repeat {
+				repeat = false
+			} else {
+				table = false
+			}
+		case SEMI_COLON:
+			pending = table
+		}
+	}
+	return b.String()
+}
+
+// spaced tells whether a space goes between prev and t.
+func spaced(prev, t formatToken, repeat, table bool) bool {
+	if repeat {
+		return false
+	}
+	switch t.Type {
+	case C_PAREN, C_ANGLE, STAR, PLUS, OPTIONAL, COMMA, SEMI_COLON, COLON:
+		return false
+	case O_PAREN:
+		if prev.Type == ID && (prev.Value == "sep" || prev.Value == "operators") {
+			return false
+		}
+	case O_ANGLE:
+		if prev.Type == ID {
+			return false
+		}
+	}
+	switch prev.Type {
+	case O_PAREN, O_ANGLE, AMPERSAND, BANG:
+		return false
+	case COLON:
+		return table
+	}
+	return true
+}
+
+// layout puts the rendered items on their lines, aligning the '=' of runs of
+// tokens or constructs that aren't split up by blank lines or comments.
+func layout(items []formatItem, trailing []Comment) []byte {
+	var b strings.Builder
+	end := 0
+
+	// comments writes the comments before something starting on line next.
+	// Those on the line the last declaration ended on go at its end.
+	comments := func(cs []Comment, next int) {
+		for _, c := range cs {
+			if c.Pos.Line == end && b.Len() > 0 {
+				b.WriteString(" " + c.Text)
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+				if c.Pos.Line > end+1 {
+					b.WriteByte('\n')
+				}
+			}
+			b.WriteString(c.Text)
+			end = commentEnd(c)
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+			if next > end+1 {
+				b.WriteByte('\n')
+			}
+		}
+	}
+
+	width := 0
+	for i, it := range items {
+		first := it.Toks[0]
+		comments(first.Comments, first.Pos.Line)
+
+		if it.Kind == FORMAT_OTHER {
+			b.WriteString(it.Left)
+		} else {
+			if runStart(items, i) {
+				width = 0
+				for j := i; j < len(items) && (j == i || !runStart(items, j)); j++ {
+					width = max(width, len(items[j].Left))
+				}
+			}
+			b.WriteString(it.Left + strings.Repeat(" ", width-len(it.Left)) + " =")
+			if !strings.HasPrefix(it.Right, "\n") {
+				b.WriteByte(' ')
+			}
+			b.WriteString(it.Right)
+		}
+		end = it.Toks[len(it.Toks)-1].end()
+	}
+	comments(trailing, end+1)
+	return []byte(b.String())
+}
+
+// runStart tells whether item i starts a new run of aligned declarations:
+// it's of another kind than the one before, or set apart from it by a blank
+// line or a comment, or one of them takes more than one line.
+func runStart(items []formatItem, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, it := items[i-1], items[i]
+	if prev.Kind != it.Kind || strings.Contains(prev.Left+prev.Right+it.Left, "\n") {
+		return true
+	}
+	end := prev.Toks[len(prev.Toks)-1].end()
+	for _, c := range it.Toks[0].Comments {
+		if c.Pos.Line != end {
+			return true
+		}
+	}
+	return it.Toks[0].Pos.Line > end+1
+}
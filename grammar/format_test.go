@@ -0,0 +1,156 @@
+package grammar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// formatSamples are grammars written every which way, formatted along with
+// the example grammars of the repository.
+var formatSamples = map[string]string{
+	"cramped": `tok ID=/[a-z]+/ tok NUM=/[0-9]+/ skip WS=/[ ]+/ ->p=(s ";")*;s=ID"="NUM|ID ( "(" ")" )?;`,
+	"templates": `
+		list < X , SEP > = X ( SEP X ) * ;
+		-> args = list<ID, ",">  ;
+		tok ID = /[a-z]+/
+	`,
+	"operators": `
+		tok NUM = /[0-9]+/
+		-> expr = operators(NUM) { left 10: "+" | "-"; right 20: "^"; prefix 30: "-"; };
+	`,
+	"long alternatives": `
+		tok ID = /[a-z]+/
+		-> stmt = "if" cond:ID block | "while" cond:ID block | "return" value:ID? ";" | ID "=" ID ";";
+		block = "{" stmt* "}";
+	`,
+	"directives": `
+		predictive;
+		packrat;
+		tok ID = /[a-z]+/ prefix { int depth = 0; }
+		-> p = ID{2,3} sep(ID, ",", trailing) !";" &ID;
+	`,
+}
+
+// formatTwice formats text, then formats the result again, failing unless
+// the second pass changes nothing.
+func formatTwice(t *testing.T, text string) string {
+	t.Helper()
+	once, err := Format([]byte(text), "test.chisel")
+	if err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	twice, err := Format(once, "test.chisel")
+	if err != nil {
+		t.Fatalf("Format failed on its own output: %v\n%s", err, once)
+	}
+	if string(twice) != string(once) {
+		t.Errorf("formatting again changes\n%s\nto\n%s", once, twice)
+	}
+	return string(once)
+}
+
+// grammarTokens lists the tokens of text by type and spelling, which
+// formatting must keep.
+func grammarTokens(t *testing.T, text string) []string {
+	t.Helper()
+	toks, _, err := readFormatTokens(NewSource("test.chisel", []byte(text)))
+	if err != nil {
+		t.Fatalf("reading tokens failed: %v\n%s", err, text)
+	}
+	res := make([]string, len(toks))
+	for i, tok := range toks {
+		res[i] = fmt.Sprintf("%d %s", tok.Type, tok.Value)
+	}
+	return res
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	samples := map[string]string{}
+	for name, text := range formatSamples {
+		samples[name] = text
+	}
+	examples, err := filepath.Glob("../*/*.chisel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(examples) == 0 {
+		t.Fatal("no example grammars found")
+	}
+	for _, path := range examples {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples[path] = string(text)
+	}
+
+	for name, text := range samples {
+		t.Run(name, func(t *testing.T) {
+			formatted := formatTwice(t, text)
+			if want, got := strings.Join(grammarTokens(t, text), "\n"), strings.Join(grammarTokens(t, formatted), "\n"); got != want {
+				t.Errorf("formatting changed the tokens to\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	text := `// c1 leading
+/* c2 block
+   spanning */
+tok ID = /[a-z]+/ // c3 after a token
+tok /* c4 inside a token */ NUM = /[0-9]+/
+predictive; // c5 after a directive
+
+// c6 before a construct
+-> program = // c7 after =
+	stmt /* c8 before a suffix */ * ; // c9 after ;
+stmt = ID "=" NUM ";" // c10 before |
+	| /* c11 after | */ ID "(" ")" ";"
+	| block; /* c12 block after ; */
+block = "{" // c13 inside a construct
+	stmt* "}";
+list<X> = X ("," /* c14 inside a group */ X)*; ;
+// c15 after a stray ;
+args = list<NUM /* c16 inside arguments */>;
+// c17 at the end
+`
+	formatted := formatTwice(t, text)
+
+	last := -1
+	for _, c := range []string{
+		"// c1 leading", "/* c2 block\n   spanning */", "// c3 after a token", "/* c4 inside a token */",
+		"// c5 after a directive", "// c6 before a construct", "// c7 after =", "/* c8 before a suffix */",
+		"// c9 after ;", "// c10 before |", "/* c11 after | */", "/* c12 block after ; */",
+		"// c13 inside a construct", "/* c14 inside a group */", "// c15 after a stray ;",
+		"/* c16 inside arguments */", "// c17 at the end",
+	} {
+		at := strings.Index(formatted, c)
+		if at < 0 {
+			t.Errorf("formatting lost %q:\n%s", c, formatted)
+			continue
+		}
+		if strings.Count(formatted, c) != 1 {
+			t.Errorf("formatting duplicated %q:\n%s", c, formatted)
+		}
+		if at < last {
+			t.Errorf("formatting moved %q:\n%s", c, formatted)
+		}
+		last = at
+	}
+
+	want := grammarTokens(t, text)
+	// The stray ';' is dropped.
+	for i, tok := range want {
+		if strings.HasSuffix(tok, " ;") && want[i-1] == tok {
+			want = append(want[:i], want[i+1:]...)
+			break
+		}
+	}
+	if got := grammarTokens(t, formatted); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatting changed the tokens to\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
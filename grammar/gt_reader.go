@@ -1,13 +1,13 @@
 package grammar
 
-import "bufio"
+import "io"
 
 type GrammarReader struct {
-	reader *bufio.Reader
+	reader *SourceReader
 	buffer []GrammarToken
 }
 
-func NewGrammarReader(r *bufio.Reader) *GrammarReader {
+func NewGrammarReader(r *SourceReader) *GrammarReader {
 	return &GrammarReader{
 		reader: r,
 	}
@@ -33,3 +33,46 @@ func (r *GrammarReader) Peek() (GrammarToken, error) {
 	r.buffer = append(r.buffer, f)
 	return f, nil
 }
+
+// PeekDeclaration peeks at the token starting a declaration, typed as the
+// keyword it is there if any.
+func (r *GrammarReader) PeekDeclaration() (GrammarToken, error) {
+	tok, err := r.Peek()
+	if err != nil || tok.Type != ID {
+		return tok, err
+	}
+	if len(r.buffer) < 2 {
+		next, err := ReadGrammarToken(r.reader)
+		if err != nil && err != io.EOF {
+			return GrammarToken{}, err
+		}
+		if err == nil {
+			r.buffer = append(r.buffer, next)
+		}
+	}
+	var next GrammarToken
+	if len(r.buffer) > 1 {
+		next = r.buffer[1]
+	}
+	r.buffer[0].Type = declarationKeyword(tok, next)
+	return r.buffer[0], nil
+}
+
+// Pos returns the position of the next unread grammar token, or of the end of
+// the file once everything has been read.
+func (r *GrammarReader) Pos() Position {
+	if len(r.buffer) > 0 {
+		return r.buffer[0].Pos
+	}
+	return r.reader.Pos()
+}
+
+// ReadExpecting reads the next token, turning the end of the file into an
+// error that names what was expected there.
+func (r *GrammarReader) ReadExpecting(what string) (GrammarToken, error) {
+	tok, err := r.Read()
+	if err == io.EOF {
+		return GrammarToken{}, errorAt(r.Pos(), "Unexpected end of file, expected %s!", what)
+	}
+	return tok, err
+}
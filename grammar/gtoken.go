@@ -1,8 +1,7 @@
 package grammar
 
 import (
-	"bufio"
-	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"unicode"
@@ -25,6 +24,13 @@ var tokens = []string{
 	"+",
 	"?",
 	"|",
+	":",
+	",",
+	"%",
+	"&",
+	"!",
+	"<",
+	">",
 }
 
 type GrammarTokenType int
@@ -34,11 +40,17 @@ const (
 	CPP_CODE
 	STRING
 	INT
+	REGEX
 
 	PREFIX
 	SUFFIX
 	TOK
 	SKIP
+	// The directive keywords are read as ids, see declarationKeyword.
+	IMPORT
+	PREDICTIVE
+	PACKRAT
+	RECOVER
 
 	ARROW
 	EQ
@@ -51,24 +63,57 @@ const (
 	PLUS
 	OPTIONAL
 	PIPE
+	COLON
+	COMMA
+	PERCENT
+	AMPERSAND
+	BANG
+	O_ANGLE
+	C_ANGLE
 )
 
 type GrammarToken struct {
 	Type  GrammarTokenType
 	Value string
+	Pos   Position
+	// Comments holds the comments between the previous token and this one.
+	Comments []Comment
 }
 
-func ReadGrammarToken(r *bufio.Reader) (GrammarToken, error) {
+// Comment is a comment as written, markers included.
+type Comment struct {
+	Text string
+	Pos  Position
+}
+
+// line tells whether the comment runs to the end of its line.
+func (c Comment) line() bool {
+	return !strings.HasPrefix(c.Text, "/*")
+}
+
+func ReadGrammarToken(r *SourceReader) (GrammarToken, error) {
 	if err := skipWhitespace(r); err != nil {
 		return GrammarToken{}, err
 	}
+	comments := r.comments
+	r.comments = nil
+
+	tok, err := readGrammarToken(r)
+	tok.Comments = comments
+	return tok, err
+}
 
+func readGrammarToken(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
 	for _, tok := range tokens {
-		b, err := r.Peek(len(tok))
-		if err != nil {
-			return GrammarToken{}, err
+		b, _ := r.Peek(len(tok) + 1)
+		// Keywords only count as a whole word, 'tokens' is an id.
+		if validIdStarter(tok[0]) && len(b) > len(tok) && validId(b[len(tok)]) {
+			continue
+		}
+		if len(b) > len(tok) {
+			b = b[:len(tok)]
 		}
-
 		if tok == string(b) {
 			if _, err := r.Discard(len(tok)); err != nil {
 				return GrammarToken{}, err
@@ -76,6 +121,7 @@ func ReadGrammarToken(r *bufio.Reader) (GrammarToken, error) {
 			return GrammarToken{
 				Type:  tokenTypeFromString(tok),
 				Value: tok,
+				Pos:   pos,
 			}, nil
 		}
 	}
@@ -95,6 +141,8 @@ func ReadGrammarToken(r *bufio.Reader) (GrammarToken, error) {
 		return readString(r)
 	case '[':
 		return readCode(r)
+	case '/':
+		return readRegex(r)
 	case '-':
 		fallthrough
 	default:
@@ -105,14 +153,15 @@ func ReadGrammarToken(r *bufio.Reader) (GrammarToken, error) {
 	}
 }
 
-func readInt(r *bufio.Reader) (GrammarToken, error) {
+func readInt(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
 	b, err := r.ReadByte()
 	if err != nil {
 		return GrammarToken{}, err
 	}
 
 	if !(b >= '0' && b <= '9') && b != '-' {
-		return GrammarToken{}, fmt.Errorf("Expected integer to start with either a digit or '-', got '%c'!", b)
+		return GrammarToken{}, errorAt(pos, "Expected integer to start with either a digit or '-', got '%c'!", b)
 	}
 
 	var sb strings.Builder
@@ -120,6 +169,9 @@ func readInt(r *bufio.Reader) (GrammarToken, error) {
 
 	for {
 		b, err = r.ReadByte()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return GrammarToken{}, err
 		}
@@ -135,13 +187,19 @@ func readInt(r *bufio.Reader) (GrammarToken, error) {
 		sb.WriteByte(b)
 	}
 
+	if sb.String() == "-" {
+		return GrammarToken{}, errorAt(pos, "Expected digits to follow '-'!")
+	}
+
 	return GrammarToken{
 		Type:  INT,
 		Value: sb.String(),
+		Pos:   pos,
 	}, nil
 }
 
-func readString(r *bufio.Reader) (GrammarToken, error) {
+func readString(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
 	b, err := r.ReadByte()
 	if err != nil {
 		return GrammarToken{}, err
@@ -149,7 +207,7 @@ func readString(r *bufio.Reader) (GrammarToken, error) {
 
 	// Check if it starts with a quote or apostrophe
 	if b != '"' && b != '\'' {
-		return GrammarToken{}, fmt.Errorf("Expected '\"' or \"'\" before string starts! Got '%c'", b)
+		return GrammarToken{}, errorAt(pos, "Expected '\"' or \"'\" before string starts! Got '%c'", b)
 	}
 
 	quoteChar := b
@@ -159,6 +217,9 @@ func readString(r *bufio.Reader) (GrammarToken, error) {
 	escaped := false
 	for {
 		b, err = r.ReadByte()
+		if err == io.EOF {
+			return GrammarToken{}, errorAt(pos, "Unterminated string literal!")
+		}
 		if err != nil {
 			return GrammarToken{}, err
 		}
@@ -184,29 +245,34 @@ func readString(r *bufio.Reader) (GrammarToken, error) {
 	quoted := str.String()
 	unquoted, err := strconv.Unquote(quoted)
 	if err != nil {
-		return GrammarToken{}, fmt.Errorf("Invalid string literal: %v", err)
+		return GrammarToken{}, errorAt(pos, "Invalid string literal: %v", err)
 	}
 
 	return GrammarToken{
 		Type:  STRING,
 		Value: unquoted,
+		Pos:   pos,
 	}, nil
 }
 
-func readCode(r *bufio.Reader) (GrammarToken, error) {
+func readCode(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
 	b, err := r.ReadByte()
 	if err != nil {
 		return GrammarToken{}, err
 	}
 
 	if b != '[' {
-		return GrammarToken{}, fmt.Errorf("Expected '[' before code segment starts!")
+		return GrammarToken{}, errorAt(pos, "Expected '[' before code segment starts!")
 	}
 
 	count := 1
 	var code strings.Builder
 	for {
 		b, err = r.ReadByte()
+		if err == io.EOF {
+			return GrammarToken{}, errorAt(pos, "Unterminated code block, expected a matching ']'!")
+		}
 		if err != nil {
 			return GrammarToken{}, err
 		}
@@ -230,17 +296,77 @@ func readCode(r *bufio.Reader) (GrammarToken, error) {
 	return GrammarToken{
 		Type:  CPP_CODE,
 		Value: code.String(),
+		Pos:   pos,
 	}, nil
 }
 
-func readId(r *bufio.Reader) (GrammarToken, error) {
-	validIdStarter := func(b byte) bool {
-		return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_'
+// readRegex reads a /pattern/ literal. A '/' inside a character class or
+// escaped as '\/' doesn't end the pattern.
+func readRegex(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
+	b, err := r.ReadByte()
+	if err != nil {
+		return GrammarToken{}, err
 	}
-	validId := func(b byte) bool {
-		return validIdStarter(b) || (b >= '0' && b <= '9')
+
+	if b != '/' {
+		return GrammarToken{}, errorAt(pos, "Expected '/' before pattern starts!")
 	}
 
+	var pattern strings.Builder
+	inClass := false
+	for {
+		b, err = r.ReadByte()
+		if err == io.EOF || b == '\n' {
+			return GrammarToken{}, errorAt(pos, "Unterminated pattern, expected a closing '/'!")
+		}
+		if err != nil {
+			return GrammarToken{}, err
+		}
+
+		if b == '/' && !inClass {
+			break
+		}
+
+		switch b {
+		case '\\':
+			n, err := r.ReadByte()
+			if err == io.EOF || n == '\n' {
+				return GrammarToken{}, errorAt(pos, "Unterminated pattern, expected a closing '/'!")
+			}
+			if err != nil {
+				return GrammarToken{}, err
+			}
+			if n != '/' {
+				pattern.WriteByte(b)
+			}
+			pattern.WriteByte(n)
+			continue
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		}
+		pattern.WriteByte(b)
+	}
+
+	return GrammarToken{
+		Type:  REGEX,
+		Value: pattern.String(),
+		Pos:   pos,
+	}, nil
+}
+
+func validIdStarter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_'
+}
+
+func validId(b byte) bool {
+	return validIdStarter(b) || (b >= '0' && b <= '9')
+}
+
+func readId(r *SourceReader) (GrammarToken, error) {
+	pos := r.Pos()
 	b, err := r.ReadByte()
 	if err != nil {
 		return GrammarToken{}, err
@@ -251,12 +377,15 @@ func readId(r *bufio.Reader) (GrammarToken, error) {
 	}
 
 	if !validIdStarter(b) {
-		return GrammarToken{}, fmt.Errorf("Expected valid id starter in the form [a-zA-Z_]. Got '%c'!", b)
+		return GrammarToken{}, errorAt(pos, "Expected valid id starter in the form [a-zA-Z_]. Got '%c'!", b)
 	}
 
 	var id strings.Builder
 	for {
 		b, err = r.ReadByte()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return GrammarToken{}, err
 		}
@@ -275,25 +404,117 @@ func readId(r *bufio.Reader) (GrammarToken, error) {
 	return GrammarToken{
 		Type:  ID,
 		Value: id.String(),
+		Pos:   pos,
 	}, nil
 }
 
-func skipWhitespace(r *bufio.Reader) error {
+// skipWhitespace discards whitespace and keeps comments for the next token.
+// Line comments start with '//' or '#', block comments are wrapped in '/*'
+// and '*/'. Strings and code blocks are read byte by byte by their own
+// readers, so comment markers inside them are never seen here.
+func skipWhitespace(r *SourceReader) error {
 	for {
-		b, err := r.ReadByte()
+		n, err := r.Peek(1)
 		if err != nil {
 			return err
 		}
 
-		if !unicode.IsSpace(rune(b)) {
-			break
+		if unicode.IsSpace(rune(n[0])) {
+			if _, err := r.Discard(1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		start := r.Pos()
+		keep := func(err error) error {
+			text := strings.TrimRight(string(r.source.Text[start.Offset:r.offset]), "\r\n")
+			r.comments = append(r.comments, Comment{Text: text, Pos: start})
+			return err
 		}
+
+		if n[0] == '#' {
+			if err := keep(skipLineComment(r)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if n[0] == '/' {
+			n, err = r.Peek(2)
+			if len(n) < 2 {
+				return nil
+			}
+			if n[1] == '/' {
+				if err := keep(skipLineComment(r)); err != nil {
+					return err
+				}
+				continue
+			}
+			if n[1] == '*' {
+				if err := skipBlockComment(r); err != nil {
+					return err
+				}
+				keep(nil)
+				continue
+			}
+		}
+		return nil
 	}
+}
 
-	if err := r.UnreadByte(); err != nil {
+func skipLineComment(r *SourceReader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == '\n' {
+			return nil
+		}
+	}
+}
+
+func skipBlockComment(r *SourceReader) error {
+	pos := r.Pos()
+	if _, err := r.Discard(2); err != nil {
 		return err
 	}
-	return nil
+
+	prev := byte(0)
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return errorAt(pos, "Unterminated block comment!")
+		}
+		if err != nil {
+			return err
+		}
+		if prev == '*' && b == '/' {
+			return nil
+		}
+		prev = b
+	}
+}
+
+// declarationKeywords only start a directive at the start of a declaration,
+// so grammars can still use them as names.
+var declarationKeywords = map[string]GrammarTokenType{
+	"import":     IMPORT,
+	"predictive": PREDICTIVE,
+	"packrat":    PACKRAT,
+	"recover":    RECOVER,
+}
+
+// declarationKeyword returns the type of tok at the start of a declaration,
+// next being the token after it. `import = ...;` or `recover<T> = ...;`
+// defines a construct, so there the word stays an id.
+func declarationKeyword(tok, next GrammarToken) GrammarTokenType {
+	keyword, ok := declarationKeywords[tok.Value]
+	if !ok || tok.Type != ID || next.Type == EQ || next.Type == O_ANGLE {
+		return tok.Type
+	}
+	return keyword
 }
 
 func tokenTypeFromString(s string) GrammarTokenType {
@@ -329,6 +550,20 @@ func tokenTypeFromString(s string) GrammarTokenType {
 		return OPTIONAL
 	case "|":
 		return PIPE
+	case ":":
+		return COLON
+	case ",":
+		return COMMA
+	case "%":
+		return PERCENT
+	case "&":
+		return AMPERSAND
+	case "!":
+		return BANG
+	case "<":
+		return O_ANGLE
+	case ">":
+		return C_ANGLE
 	default:
 		if s[0] == '[' {
 			return CPP_CODE
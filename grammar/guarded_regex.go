@@ -0,0 +1,87 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GuardedRegex is the predictive form of x?, x*, x+ and x{m,n}: past its
+// minimum count it only runs x again if the next token can start it, so
+// once it does, a failure of x is a real syntax error.
+type GuardedRegex struct {
+	Inner Transpilable
+	Min   int
+	// Max is -1 when there's no upper bound.
+	Max int
+	// Tokens is the FIRST set of Inner.
+	Tokens []string
+	Pos    Position
+}
+
+func (r *GuardedRegex) Name() string {
+	max := "n"
+	if r.Max >= 0 {
+		max = fmt.Sprint(r.Max)
+	}
+	return mangled(symbol(r.Inner), fmt.Sprint(r.Min), max)
+}
+
+func (r *GuardedRegex) Prototype() (string, error) {
+	return WriteString("Result guarded{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+func (r *GuardedRegex) Function() (string, error) {
+	cond := "true"
+	if r.Max >= 0 {
+		cond = fmt.Sprintf("count < %d", r.Max)
+	}
+
+	return WriteString(
+		`
+		Result Lexer::guarded{{.Name}}(std::vector<Node> &nodes) {
+			auto start = checkpoint(nodes);
+			{{- if .Min}}
+			for (int count = 0; count < {{.Min}}; ++count) {
+				auto res = {{.InnerCall}};
+				if (!res) {
+					rollback(start, nodes);
+					return res;
+				}
+			}
+			{{- end}}
+			for (int count = {{.Min}}; {{.Cond}}; ++count) {
+				auto token = peek();
+				if (!({{.Match}})) {
+					expect({{.Expected}});
+					break;
+				}
+				auto before = mark();
+				auto res = {{.InnerCall}};
+				if (!res) {
+					rollback(start, nodes);
+					return res;
+				}
+				if (!advanced(before))
+					break;
+			}
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":      r.Name(),
+			"Min":       r.Min,
+			"Cond":      cond,
+			"Match":     oneOf(r.Tokens),
+			"Expected":  tokenTypes(r.Tokens),
+			"InnerCall": r.Inner.Call("nodes"),
+		},
+	)
+}
+
+func (r *GuardedRegex) Call(args ...string) string {
+	return fmt.Sprintf("guarded%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *GuardedRegex) Accumulate() []Transpilable {
+	return append([]Transpilable{r}, r.Inner.Accumulate()...)
+}
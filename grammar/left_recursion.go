@@ -0,0 +1,240 @@
+package grammar
+
+import (
+	"strings"
+)
+
+/*
+ * Recursive descent can't parse left recursion: a construct that may call
+ * itself before consuming any input recurses forever. Direct left recursion
+ * in a top level alternative is rewritten into a LeftRecursiveRegex, anything
+ * else (indirect, or hidden inside a group) is reported with its cycle.
+ */
+
+// rewriteLeftRecursion returns the iterative form of value if some of its top
+// level alternatives start with a reference to the construct itself.
+func rewriteLeftRecursion(name string, value Transpilable, pos Position) (Transpilable, error) {
+	alts := []Transpilable{value}
+	if or, ok := value.(*OrRegex); ok {
+		alts = or.Chain
+	}
+
+	base := []Transpilable{}
+	tails := []LeftRecursiveTail{}
+	for _, alt := range alts {
+		chain := []Transpilable{alt}
+		if c, ok := alt.(*ChainRegex); ok {
+			chain = c.Chain
+		}
+
+		field := ""
+		head := chain[0]
+		if f, ok := head.(*FieldRegex); ok {
+			field, head = f.Field, f.Inner
+		}
+		if n, ok := head.(*NestedRegex); !ok || n.Inner != name {
+			base = append(base, alt)
+			continue
+		}
+
+		if len(chain) == 1 {
+			return nil, errorAt(head.(*NestedRegex).Pos, "The alternative '%s' of construct '%s' only calls itself!", describe(alt), name)
+		}
+		tail := chain[1]
+		if len(chain) > 2 {
+			tail = &ChainRegex{Chain: chain[1:], Pos: alt.(*ChainRegex).Pos}
+		}
+		tails = append(tails, LeftRecursiveTail{Field: field, Tail: tail})
+	}
+
+	if len(tails) == 0 {
+		return value, nil
+	}
+	if len(base) == 0 {
+		return nil, errorAt(pos, "Construct '%s' is left recursive in every alternative, so it can never finish!", name)
+	}
+
+	var b Transpilable = base[0]
+	if len(base) > 1 {
+		b = &OrRegex{Chain: base, Pos: pos}
+	}
+	return &LeftRecursiveRegex{
+		Construct:    name,
+		Alternatives: alts,
+		Base:         b,
+		Tails:        tails,
+		Pos:          pos,
+	}, nil
+}
+
+// nullableConstructs finds the constructs that can match without consuming
+// any input.
+func nullableConstructs(constructs []Construct) map[string]bool {
+	res := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, c := range constructs {
+			if !res[c.Name()] && nullable(c.Value, res) {
+				res[c.Name()] = true
+				changed = true
+			}
+		}
+	}
+	return res
+}
+
+// nullable reports whether t can match without consuming any input.
+func nullable(t Transpilable, constructs map[string]bool) bool {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return false
+	case *NestedRegex:
+		return constructs[r.Inner]
+	case *PredicateRegex, *OptionalRegex:
+		return true
+	case *FieldRegex:
+		return nullable(r.Inner, constructs)
+	case *CapturedRegex:
+		return nullable(r.Inner, constructs)
+	case *RepeatRegex:
+		return r.Min == 0 || nullable(r.Inner, constructs)
+	case *MultiplierRegex:
+		return !r.RequireOne || nullable(r.Inner, constructs)
+	case *SeparatedRegex:
+		return r.Empty || nullable(r.Item, constructs)
+	case *OperatorRegex:
+		return nullable(r.Operand, constructs)
+	case *LeftRecursiveRegex:
+		return nullable(r.Base, constructs)
+	case *ChainRegex:
+		for _, c := range r.Chain {
+			if !nullable(c, constructs) {
+				return false
+			}
+		}
+		return true
+	case *OrRegex:
+		for _, c := range r.Chain {
+			if nullable(c, constructs) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// leftCalls lists the constructs t may run before it has consumed any input.
+func leftCalls(t Transpilable, constructs map[string]bool) []*NestedRegex {
+	switch r := t.(type) {
+	case *NestedRegex:
+		return []*NestedRegex{r}
+	case *PredicateRegex:
+		return leftCalls(r.Inner, constructs)
+	case *FieldRegex:
+		return leftCalls(r.Inner, constructs)
+	case *CapturedRegex:
+		return leftCalls(r.Inner, constructs)
+	case *OptionalRegex:
+		return leftCalls(r.Inner, constructs)
+	case *RepeatRegex:
+		return leftCalls(r.Inner, constructs)
+	case *MultiplierRegex:
+		return leftCalls(r.Inner, constructs)
+	case *SeparatedRegex:
+		res := leftCalls(r.Item, constructs)
+		if nullable(r.Item, constructs) {
+			res = append(res, leftCalls(r.Separator, constructs)...)
+		}
+		return res
+	case *OperatorRegex:
+		return leftCalls(r.Operand, constructs)
+	case *LeftRecursiveRegex:
+		res := leftCalls(r.Base, constructs)
+		if nullable(r.Base, constructs) {
+			for _, t := range r.Tails {
+				res = append(res, leftCalls(t.Tail, constructs)...)
+			}
+		}
+		return res
+	case *ChainRegex:
+		res := []*NestedRegex{}
+		for _, c := range r.Chain {
+			res = append(res, leftCalls(c, constructs)...)
+			if !nullable(c, constructs) {
+				break
+			}
+		}
+		return res
+	case *OrRegex:
+		res := []*NestedRegex{}
+		for _, c := range r.Chain {
+			res = append(res, leftCalls(c, constructs)...)
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+// leftRecursionCycles reports every cycle of constructs that can reach
+// themselves again without consuming input.
+func leftRecursionCycles(constructs []Construct) Diagnostics {
+	nullables := nullableConstructs(constructs)
+	index := map[string]int{}
+	for i, c := range constructs {
+		index[c.Name()] = i
+	}
+	edges := make([][]*NestedRegex, len(constructs))
+	for i, c := range constructs {
+		edges[i] = leftCalls(c.Value, nullables)
+	}
+
+	// A cycle is reported from its earliest construct only, and every
+	// construct on it is left out of later searches.
+	diags := Diagnostics{}
+	reported := map[int]bool{}
+	for start := range constructs {
+		if reported[start] {
+			continue
+		}
+
+		// Breadth first, so the shortest cycle is the one shown.
+		prev := map[int]int{}
+		queue := []int{start}
+		found := false
+		for len(queue) > 0 && !found {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, call := range edges[cur] {
+				next, ok := index[call.Inner]
+				if !ok || next < start || reported[next] {
+					continue
+				}
+				if next == start {
+					prev[start] = cur
+					found = true
+					break
+				}
+				if _, seen := prev[next]; !seen {
+					prev[next] = cur
+					queue = append(queue, next)
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+
+		path := []string{constructs[start].Name()}
+		for cur := prev[start]; cur != start; cur = prev[cur] {
+			path = append([]string{constructs[cur].Name()}, path...)
+			reported[cur] = true
+		}
+		path = append([]string{constructs[start].Name()}, path...)
+		reported[start] = true
+		diags.add(ERROR, constructs[start].Pos, "Left recursion: %s! Every construct on the cycle can call the next one before consuming any input.", strings.Join(path, " -> "))
+	}
+	return diags
+}
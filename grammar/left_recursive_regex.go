@@ -0,0 +1,97 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LeftRecursiveRegex is the iterative form of a directly left recursive
+// construct like `expr = expr PLUS term | term;`. It parses one of the Base
+// alternatives, then keeps extending the node with the tail of a recursive
+// alternative (PLUS term), each time moving what was parsed so far into a new
+// child of the same type. That builds the same left associative tree the
+// recursion describes.
+type LeftRecursiveRegex struct {
+	Construct string
+	// Alternatives are the construct's alternatives as written.
+	Alternatives []Transpilable
+	Base         Transpilable
+	Tails        []LeftRecursiveTail
+	Pos          Position
+}
+
+// LeftRecursiveTail is a recursive alternative without its leading reference
+// to the construct. Field is the label that reference had, if any.
+type LeftRecursiveTail struct {
+	Field string
+	Tail  Transpilable
+}
+
+func (r *LeftRecursiveRegex) Name() string {
+	return r.Construct
+}
+
+func (r *LeftRecursiveRegex) Prototype() (string, error) {
+	return WriteString("Result leftrec{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+func (r *LeftRecursiveRegex) Function() (string, error) {
+	tails := []string{}
+	for _, t := range r.Tails {
+		mark := ""
+		if t.Field != "" {
+			mark = fmt.Sprintf("current->mark(ParseNode::Field::%s, 0, 1);\n\t\t\t\t\t", t.Field)
+		}
+		s, err := WriteString(
+			`current->nest();
+				if ({{.TailCall}}) {
+					{{.Mark}}if (!advanced(before))
+						break;
+					continue;
+				}
+				current->unnest();`,
+			map[string]any{
+				"TailCall": t.Tail.Call("nodes"),
+				"Mark":     mark,
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+		tails = append(tails, s)
+	}
+
+	return WriteString(
+		`
+		Result Lexer::leftrec{{.Name}}(std::vector<Node> &nodes) {
+			auto res = {{.BaseCall}};
+			if (!res)
+				return res;
+			while (true) {
+				auto before = mark();
+				{{.Tails}}
+				break;
+			}
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":     r.Name(),
+			"BaseCall": r.Base.Call("nodes"),
+			"Tails":    strings.Join(tails, "\n\t\t\t\t"),
+		},
+	)
+}
+
+func (r *LeftRecursiveRegex) Call(args ...string) string {
+	return fmt.Sprintf("leftrec%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *LeftRecursiveRegex) Accumulate() []Transpilable {
+	c := []Transpilable{r}
+	c = append(c, r.Base.Accumulate()...)
+	for _, t := range r.Tails {
+		c = append(c, t.Tail.Accumulate()...)
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registerLiterals makes every string literal used inside a construct body
+// refer to a LITERAL token. Literals that match a declared token reuse it, the
+// rest become anonymous tokens that are inserted next to the declared literals
+// so they end up in the same lexer trie.
+func registerLiterals(readData *ReadData) error {
+	taken := map[string]bool{}
+	known := map[string]bool{}
+	for _, tok := range readData.Tokens {
+		taken[tok.Name()] = true
+		if tok.Type == LITERAL && !tok.Skip {
+			known[tok.Value] = true
+		}
+	}
+	for _, sc := range readData.SimpleConstructs {
+		taken[sc.Name] = true
+	}
+
+	anonymous := []Token{}
+	for _, sc := range readData.SimpleConstructs {
+		for _, gtok := range sc.Value {
+			if gtok.Type != STRING || known[gtok.Value] {
+				continue
+			}
+			if gtok.Value == "" {
+				return errorAt(gtok.Pos, "Empty string literals can't be matched!")
+			}
+
+			name := literalName(gtok.Value)
+			for i := 2; taken[name]; i++ {
+				name = fmt.Sprintf("%s_%d", literalName(gtok.Value), i)
+			}
+			taken[name] = true
+			known[gtok.Value] = true
+
+			anonymous = append(anonymous, Token{
+				name:      name,
+				Type:      LITERAL,
+				Value:     gtok.Value,
+				Anonymous: true,
+				Pos:       gtok.Pos,
+			})
+		}
+	}
+	if len(anonymous) == 0 {
+		return nil
+	}
+
+	at := 0
+	for i, tok := range readData.Tokens {
+		if tok.Type == LITERAL && !tok.Skip {
+			at = i + 1
+		}
+	}
+	precedence := 0
+	if at > 0 {
+		precedence = readData.Tokens[at-1].Precedence
+	}
+	for i := range anonymous {
+		anonymous[i].Precedence = precedence
+	}
+
+	toks := append([]Token{}, readData.Tokens[:at]...)
+	toks = append(toks, anonymous...)
+	readData.Tokens = append(toks, readData.Tokens[at:]...)
+	return nil
+}
+
+// literalName derives a C++ identifier from a literal. Letters and digits are
+// kept, every other byte is spelled out in hex: "+=" becomes LIT_2B3D.
+func literalName(value string) string {
+	var s strings.Builder
+	s.WriteString("LIT_")
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') {
+			s.WriteByte(b)
+		} else {
+			fmt.Fprintf(&s, "%02X", b)
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,36 @@
+package grammar
+
+// nullableLoops reports every unbounded loop whose round can match without
+// consuming input, which would go round forever: `(x?)*`, `a+` over a
+// nullable construct, a separated list whose separator and items can both
+// be empty, or a left recursive alternative with nothing after the
+// recursion that has to match.
+func nullableLoops(constructs []Construct) Diagnostics {
+	nullables := nullableConstructs(constructs)
+	diags := Diagnostics{}
+	for _, c := range constructs {
+		for _, t := range c.Value.Accumulate() {
+			switch r := t.(type) {
+			case *MultiplierRegex:
+				if nullable(r.Inner, nullables) {
+					diags.add(ERROR, r.Pos, "'%s' repeats something that can match empty input, so it would never end!", describe(r))
+				}
+			case *RepeatRegex:
+				if r.Max < 0 && nullable(r.Inner, nullables) {
+					diags.add(ERROR, r.Pos, "'%s' repeats something that can match empty input, so it would never end!", describe(r))
+				}
+			case *SeparatedRegex:
+				if nullable(r.Separator, nullables) && nullable(r.Item, nullables) {
+					diags.add(ERROR, r.Pos, "'%s' can go on without consuming any input, so it would never end!", describe(r))
+				}
+			case *LeftRecursiveRegex:
+				for _, tail := range r.Tails {
+					if nullable(tail.Tail, nullables) {
+						diags.add(ERROR, r.Pos, "'%s' can extend '%s' without consuming any input, so it would never end!", describe(tail.Tail), r.Construct)
+					}
+				}
+			}
+		}
+	}
+	return diags
+}
@@ -8,10 +8,14 @@ import (
 type MultiplierRegex struct {
 	RequireOne bool
 	Inner      Transpilable
+	Pos        Position
 }
 
 func (r *MultiplierRegex) Name() string {
-	return r.Inner.Name()
+	if r.RequireOne {
+		return mangled("plus", symbol(r.Inner))
+	}
+	return mangled("star", symbol(r.Inner))
 }
 
 func (r *MultiplierRegex) Prototype() (string, error) {
@@ -25,8 +29,10 @@ func (r *MultiplierRegex) Function() (string, error) {
 			Result Lexer::mult{{.Name}}(std::vector<Node> &nodes) {
 				bool one_found = false;
 				Result res;
-				for (res = {{.InnerCall}}; res; res = {{.InnerCall}}) {
+				for (auto before = mark(); (res = {{.InnerCall}}); before = mark()) {
 					one_found = true;
+					if (!advanced(before))
+						break;
 				}
 				if (!one_found)
 					return res;
@@ -43,7 +49,10 @@ func (r *MultiplierRegex) Function() (string, error) {
 	return WriteString(
 		`
 		Result Lexer::mult{{.Name}}(std::vector<Node> &nodes) {
-			for (auto res = {{.InnerCall}}; res; res = {{.InnerCall}}) {}
+			for (auto before = mark(); {{.InnerCall}}; before = mark()) {
+				if (!advanced(before))
+					break;
+			}
 			return {};
 		}
 		`,
@@ -7,6 +7,25 @@ import (
 
 type NestedRegex struct {
 	Inner string
+	// Memoized calls of a packrat construct go through its memo table.
+	Memoized bool
+	// Sync is set on calls of a recovering construct, which skip to one of
+	// these tokens when it fails. Follow is what can come after it.
+	Sync   []string
+	Follow []string
+	// Body is set on calls of an inlined construct, which run its body
+	// right here rather than through its function.
+	Body Transpilable
+	Pos  Position
+}
+
+// builder is what builds the node of the construct: its function, or its
+// body when it's inlined.
+func (r *NestedRegex) builder() string {
+	if r.Body == nil {
+		return "&Lexer::construct" + r.Inner
+	}
+	return "[this](std::vector<Node> &children) { return " + r.Body.Call("children") + "; }"
 }
 
 func (r *NestedRegex) Name() string {
@@ -18,14 +37,70 @@ func (r *NestedRegex) Prototype() (string, error) {
 }
 
 func (r *NestedRegex) Function() (string, error) {
+	if !r.Memoized && r.Sync == nil {
+		return WriteString(
+			`
+			Result Lexer::nested{{.Name}}(std::vector<Node> &nodes) {
+				Node node(new ParseNode(ParseNode::Type::{{.Name}}));
+				auto res = build(node.node(), {{.Builder}});
+				if (res)
+					nodes.push_back(std::move(node));
+				return res;
+			}
+			`,
+			map[string]any{
+				"Name":    r.Name(),
+				"Builder": r.builder(),
+			},
+		)
+	}
+
 	return WriteString(
 		`
 		Result Lexer::nested{{.Name}}(std::vector<Node> &nodes) {
-			return construct{{.Name}}(nodes);
+			skip();
+			auto start = mark();
+			{{- if .Memoized}}
+			auto key = static_cast<std::streamoff>(start.position);
+			if (auto it = memo{{.Name}}.find(key); it != memo{{.Name}}.end()) {
+				rewind(it->second.end);
+				nodes.insert(nodes.end(), it->second.nodes.begin(), it->second.nodes.end());
+				_diagnostics.insert(_diagnostics.end(), it->second.diagnostics.begin(), it->second.diagnostics.end());
+				return it->second.result;
+			}
+			auto reported = _diagnostics.size();
+			{{- end}}
+
+			Node node(new ParseNode(ParseNode::Type::{{.Name}}));
+			{{- if .Recovered}}
+			auto outer = std::exchange(_furthest, std::nullopt);
+			{{- end}}
+			auto res = build(node.node(), {{.Builder}});
+			{{- if .Recovered}}
+			if (!res)
+				res = recover(start, node, res, {{.Sync}}, {{.Follow}});
+			restore(std::move(outer));
+			{{- end}}
+			{{- if .Memoized}}
+
+			Memo entry{res, mark(), {}, {}};
+			if (res)
+				entry.nodes.push_back(node);
+			entry.diagnostics.assign(_diagnostics.begin() + reported, _diagnostics.end());
+			memo{{.Name}}.emplace(key, std::move(entry));
+			{{- end}}
+			if (res)
+				nodes.push_back(std::move(node));
+			return res;
 		}
 		`,
 		map[string]any{
-			"Name": r.Name(),
+			"Name":      r.Name(),
+			"Builder":   r.builder(),
+			"Memoized":  r.Memoized,
+			"Recovered": r.Sync != nil,
+			"Sync":      tokenTypes(r.Sync),
+			"Follow":    tokenTypes(r.Follow),
 		},
 	)
 }
@@ -0,0 +1,482 @@
+package grammar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * An operator table replaces a hierarchy of expression constructs:
+ *
+ * expr = operators(primary) {
+ *     left 10: PLUS | MINUS;
+ *     left 20: STAR | SLASH;
+ *     right 30: POW;
+ *     prefix 40: MINUS;
+ *     postfix 50: BANG;
+ * };
+ *
+ * Higher precedences bind tighter. It compiles to a precedence climbing
+ * routine that builds flat nodes: <construct>_binary holds the left operand,
+ * the operator token and the right operand, <construct>_prefix the operator
+ * and its operand and <construct>_postfix the operand and its operator.
+ */
+
+type Fixity int
+
+const (
+	LEFT_ASSOC Fixity = iota
+	RIGHT_ASSOC
+	PREFIX_OPERATOR
+	POSTFIX_OPERATOR
+)
+
+func (f Fixity) String() string {
+	switch f {
+	case LEFT_ASSOC:
+		return "left"
+	case RIGHT_ASSOC:
+		return "right"
+	case PREFIX_OPERATOR:
+		return "prefix"
+	case POSTFIX_OPERATOR:
+		return "postfix"
+	default:
+		return fmt.Sprintf("Fixity(%d)", int(f))
+	}
+}
+
+func (f Fixity) binary() bool {
+	return f == LEFT_ASSOC || f == RIGHT_ASSOC
+}
+
+var fixities = map[string]Fixity{
+	"left":    LEFT_ASSOC,
+	"right":   RIGHT_ASSOC,
+	"prefix":  PREFIX_OPERATOR,
+	"postfix": POSTFIX_OPERATOR,
+}
+
+type OperatorLevel struct {
+	Fixity     Fixity
+	Precedence int
+	Operators  []*TokenRegex
+	Pos        Position
+}
+
+type OperatorRegex struct {
+	// Construct is the construct the table makes up, its node types are
+	// named after it.
+	Construct string
+	Operand   Transpilable
+	Levels    []OperatorLevel
+	Pos       Position
+}
+
+func (r *OperatorRegex) Name() string {
+	return r.Construct
+}
+
+func (r *OperatorRegex) has(f func(Fixity) bool) bool {
+	for _, l := range r.Levels {
+		if f(l.Fixity) {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeTypes lists the ParseNode types the table builds besides the
+// construct's own.
+func (r *OperatorRegex) NodeTypes() []string {
+	return operatorNodeTypes(r.Construct, r.has(Fixity.binary), r.has(func(f Fixity) bool { return f == PREFIX_OPERATOR }), r.has(func(f Fixity) bool { return f == POSTFIX_OPERATOR }))
+}
+
+func operatorNodeTypes(construct string, binary, prefix, postfix bool) []string {
+	types := []string{}
+	if binary {
+		types = append(types, construct+"_binary")
+	}
+	if prefix {
+		types = append(types, construct+"_prefix")
+	}
+	if postfix {
+		types = append(types, construct+"_postfix")
+	}
+	return types
+}
+
+func (r *OperatorRegex) Prototype() (string, error) {
+	return WriteString(
+		`Result operators{{.Name}}(std::vector<Node> &);
+		Result climb{{.Name}}(std::vector<Node> &, int);`,
+		map[string]any{"Name": r.Name()},
+	)
+}
+
+// levels returns the levels of the given fixities, tightest binding first.
+func (r *OperatorRegex) levels(f func(Fixity) bool) []OperatorLevel {
+	res := []OperatorLevel{}
+	for _, l := range r.Levels {
+		if f(l.Fixity) {
+			res = append(res, l)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Precedence > res[j].Precedence
+	})
+	return res
+}
+
+// matchesAny tests 'token' against the operators of level.
+func matchesAny(level OperatorLevel) string {
+	names := make([]string, len(level.Operators))
+	for i, op := range level.Operators {
+		names[i] = op.Name()
+	}
+	return oneOf(names)
+}
+
+func (r *OperatorRegex) Function() (string, error) {
+	var prefixes strings.Builder
+	for _, l := range r.levels(func(f Fixity) bool { return f == PREFIX_OPERATOR }) {
+		s, err := WriteString(
+			`if ({{.Match}}) {
+				Node node(new ParseNode(ParseNode::Type::{{.Name}}_prefix));
+				node.node().append(lex());
+				auto res = climb{{.Name}}(node.node().children(), {{.Precedence}});
+				if (!res)
+					return res;
+				left.push_back(std::move(node));
+			} else `,
+			map[string]any{
+				"Name":       r.Name(),
+				"Match":      matchesAny(l),
+				"Precedence": l.Precedence,
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+		prefixes.WriteString(s)
+	}
+
+	var infixes strings.Builder
+	for _, l := range r.levels(func(f Fixity) bool { return f != PREFIX_OPERATOR }) {
+		text := `if ({{.Match}} && {{.Precedence}} >= precedence) {
+					Node node(new ParseNode(ParseNode::Type::{{.Name}}_postfix));
+					node.node().append(std::move(left.back()));
+					node.node().append(lex());
+					left.back() = std::move(node);
+				} else `
+		if l.Fixity.binary() {
+			text = `if ({{.Match}} && {{.Precedence}} >= precedence) {
+					Node node(new ParseNode(ParseNode::Type::{{.Name}}_binary));
+					node.node().append(std::move(left.back()));
+					node.node().append(lex());
+					auto res = climb{{.Name}}(node.node().children(), {{.Next}});
+					if (!res)
+						return res;
+					left.back() = std::move(node);
+				} else `
+		}
+		// Left associative operators only take tighter operators on their
+		// right, right associative ones take themselves too.
+		next := l.Precedence
+		if l.Fixity == LEFT_ASSOC {
+			next++
+		}
+		s, err := WriteString(text, map[string]any{
+			"Name":       r.Name(),
+			"Match":      matchesAny(l),
+			"Precedence": l.Precedence,
+			"Next":       next,
+		})
+		if err != nil {
+			return "", err
+		}
+		infixes.WriteString(s)
+	}
+
+	// Whatever follows the table is tracked by the caller, only the
+	// operators that could have gone on are expected here.
+	names := []string{}
+	for _, l := range r.levels(func(f Fixity) bool { return f != PREFIX_OPERATOR }) {
+		for _, op := range l.Operators {
+			names = append(names, op.Name())
+		}
+	}
+	expected := ""
+	if len(names) > 0 {
+		expected = tokenTypes(names)
+	}
+
+	return WriteString(
+		`
+		Result Lexer::operators{{.Name}}(std::vector<Node> &nodes) {
+			// Only the input needs rolling back, climbing builds its nodes
+			// apart and only adds the finished one.
+			auto start = checkpoint(nodes);
+			auto res = climb{{.Name}}(nodes, std::numeric_limits<int>::min());
+			if (!res)
+				rollback(start, nodes);
+			return res;
+		}
+
+		Result Lexer::climb{{.Name}}(std::vector<Node> &nodes, int precedence) {
+			std::vector<Node> left;
+			auto token = peek();
+			{{.Prefixes}}{
+				auto res = {{.OperandCall}};
+				if (!res)
+					return res;
+			}
+
+			while (true) {
+				token = peek();
+				{{.Infixes}}{{if .Expected}}{
+					expect({{.Expected}});
+					break;
+				}{{else}}break;{{end}}
+			}
+			nodes.push_back(std::move(left.back()));
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":        r.Name(),
+			"Prefixes":    prefixes.String(),
+			"Infixes":     infixes.String(),
+			"OperandCall": r.Operand.Call("left"),
+			"Expected":    expected,
+		},
+	)
+}
+
+func (r *OperatorRegex) Call(args ...string) string {
+	return fmt.Sprintf("operators%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *OperatorRegex) Accumulate() []Transpilable {
+	return append([]Transpilable{r}, r.Operand.Accumulate()...)
+}
+
+type operatorEntry struct {
+	Fixity     Fixity
+	Precedence int
+	Operators  []GrammarToken
+	Pos        Position
+}
+
+// isOperatorTable reports whether the body of sc is an operator table, which
+// it is unless 'operators' names a token or construct.
+func isOperatorTable(sc SimpleConstruct, defined func(string) bool) bool {
+	v := sc.Value
+	return len(v) > 1 && v[0].Type == ID && v[0].Value == "operators" && v[1].Type == O_PAREN && !defined("operators")
+}
+
+// splitOperatorTable splits `operators(operand) { entries }` into the operand's
+// tokens, the position of the paren closing them and the table entries.
+func splitOperatorTable(sc SimpleConstruct) ([]GrammarToken, Position, []operatorEntry, error) {
+	v := sc.Value
+	depth := 0
+	closing := -1
+	for i := 1; i < len(v) && closing < 0; i++ {
+		switch v[i].Type {
+		case O_PAREN:
+			depth++
+		case C_PAREN:
+			depth--
+			if depth == 0 {
+				closing = i
+			}
+		}
+	}
+	if closing < 0 {
+		return nil, Position{}, nil, errorAt(v[1].Pos, "Unclosed '(' after 'operators'!")
+	}
+	operand := v[2:closing]
+	if len(operand) == 0 {
+		return nil, Position{}, nil, errorAt(v[closing].Pos, "'operators' needs an operand, like operators(primary)!")
+	}
+
+	rest := v[closing+1:]
+	at := func(i int) (GrammarToken, Position) {
+		if i < len(rest) {
+			return rest[i], rest[i].Pos
+		}
+		return GrammarToken{}, sc.End
+	}
+	if tok, pos := at(0); tok.Type != O_BRACE {
+		return nil, Position{}, nil, errorAt(pos, "Expected '{' to open the operator table, got '%s'!", tok.Value)
+	}
+
+	entries := []operatorEntry{}
+	i := 1
+	for {
+		tok, pos := at(i)
+		if tok.Type == C_BRACE {
+			break
+		}
+		fixity, ok := fixities[tok.Value]
+		if !ok || (tok.Type != ID && tok.Type != PREFIX) {
+			return nil, Position{}, nil, errorAt(pos, "Expected 'left', 'right', 'prefix', 'postfix' or '}', got '%s'!", tok.Value)
+		}
+		entry := operatorEntry{Fixity: fixity, Pos: pos}
+
+		tok, pos = at(i + 1)
+		if tok.Type != INT {
+			return nil, Position{}, nil, errorAt(pos, "Expected a precedence after '%s', got '%s'!", fixity, tok.Value)
+		}
+		prec, err := strconv.Atoi(tok.Value)
+		if err != nil {
+			return nil, Position{}, nil, errorAt(pos, "Invalid precedence '%s'!", tok.Value)
+		}
+		entry.Precedence = prec
+
+		if tok, pos = at(i + 2); tok.Type != COLON {
+			return nil, Position{}, nil, errorAt(pos, "Expected ':' after the precedence, got '%s'!", tok.Value)
+		}
+		for i += 3; ; i += 2 {
+			tok, pos = at(i)
+			if tok.Type != ID && tok.Type != STRING {
+				return nil, Position{}, nil, errorAt(pos, "Expected an operator token, got '%s'!", tok.Value)
+			}
+			entry.Operators = append(entry.Operators, tok)
+
+			tok, pos = at(i + 1)
+			if tok.Type == SEMI_COLON {
+				break
+			}
+			if tok.Type != PIPE {
+				return nil, Position{}, nil, errorAt(pos, "Expected '|' or ';' after the operator, got '%s'!", tok.Value)
+			}
+		}
+		entries = append(entries, entry)
+		i += 2
+	}
+
+	if tok, pos := at(i + 1); i+1 < len(rest) {
+		return nil, Position{}, nil, errorAt(pos, "Unexpected '%s' after the operator table!", tok.Value)
+	}
+	return operand, v[closing].Pos, entries, nil
+}
+
+// validateOperatorTable checks an operator table: the operand like any other
+// body, every operator must be a token, and no token may be used two
+// conflicting ways.
+func validateOperatorTable(sc SimpleConstruct, defined, isToken map[string]bool) Diagnostics {
+	diags := Diagnostics{}
+	operand, closing, entries, err := splitOperatorTable(sc)
+	if err != nil {
+		diags.addError(err)
+		return diags
+	}
+	diags = append(diags, validateConstructValue(SimpleConstruct{
+		Name:  sc.Name,
+		Value: operand,
+		Pos:   sc.Pos,
+		End:   closing,
+	}, defined)...)
+	for _, tok := range operand {
+		if tok.Type == COLON {
+			diags.add(ERROR, tok.Pos, "Fields can't be labeled inside the operand of 'operators'!")
+			break
+		}
+	}
+
+	binary := false
+	prefix, postfix := false, false
+	binaryAt := map[int]operatorEntry{}
+	roles := map[string]map[string]Position{"binary": {}, "prefix": {}, "postfix": {}}
+	for _, e := range entries {
+		role := e.Fixity.String()
+		switch e.Fixity {
+		case LEFT_ASSOC, RIGHT_ASSOC:
+			binary = true
+			role = "binary"
+			if prev, ok := binaryAt[e.Precedence]; ok && prev.Fixity != e.Fixity {
+				diags.add(ERROR, e.Pos, "Precedence %d is already %s associative at %s!", e.Precedence, prev.Fixity, prev.Pos)
+			}
+			binaryAt[e.Precedence] = e
+		case PREFIX_OPERATOR:
+			prefix = true
+		case POSTFIX_OPERATOR:
+			postfix = true
+		}
+
+		for _, op := range e.Operators {
+			if op.Type == ID && !isToken[op.Value] {
+				if defined[op.Value] {
+					diags.add(ERROR, op.Pos, "Operator '%s' is a construct, operators must be tokens!", op.Value)
+				} else {
+					diags.add(ERROR, op.Pos, "Token id '%s' not found!", op.Value)
+				}
+				continue
+			}
+			key := op.Value
+			if op.Type == STRING {
+				key = strconv.Quote(op.Value)
+			}
+			if prev, ok := roles[role][key]; ok {
+				diags.add(ERROR, op.Pos, "Operator %s is already a %s operator at %s!", key, role, prev)
+				continue
+			}
+			roles[role][key] = op.Pos
+		}
+	}
+	// Both are looked for right after an operand, so a token can't be both.
+	for key, pos := range roles["postfix"] {
+		if prev, ok := roles["binary"][key]; ok {
+			diags.add(ERROR, pos, "Operator %s can't be postfix, it's already a binary operator at %s!", key, prev)
+		}
+	}
+
+	for _, t := range operatorNodeTypes(sc.Name, binary, prefix, postfix) {
+		if defined[t] {
+			diags.add(ERROR, sc.Pos, "The operator table of '%s' needs the node type '%s', which is already defined!", sc.Name, t)
+		}
+	}
+	return diags
+}
+
+// parseOperatorTable realizes the operator table making up the body of sc.
+func parseOperatorTable(sc SimpleConstruct, tokens []Token, constructs []SimpleConstruct) (Transpilable, error) {
+	operandToks, closing, entries, err := splitOperatorTable(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	operand, err := valueOf(SimpleConstruct{
+		Name:  sc.Name,
+		Value: operandToks,
+		Pos:   sc.Pos,
+		End:   closing,
+	}, tokens, constructs)
+	if err != nil {
+		return nil, err
+	}
+	if b := nodeBounds(operand); b.min != 1 || b.max != 1 {
+		return nil, errorAt(operandToks[0].Pos, "The operand of 'operators' must produce exactly one node, wrap it in a construct!")
+	}
+
+	r := &OperatorRegex{Construct: sc.Name, Operand: operand, Pos: sc.Value[0].Pos}
+	for _, e := range entries {
+		level := OperatorLevel{Fixity: e.Fixity, Precedence: e.Precedence, Pos: e.Pos}
+		for _, op := range e.Operators {
+			t, _, err := parsePrimary([]GrammarToken{op, {Type: SEMI_COLON, Value: ";", Pos: op.Pos}}, tokens, nil)
+			if err != nil {
+				return nil, err
+			}
+			tr, ok := t.(*TokenRegex)
+			if !ok {
+				return nil, errorAt(op.Pos, "Operator '%s' must be a token!", op.Value)
+			}
+			level.Operators = append(level.Operators, tr)
+		}
+		r.Levels = append(r.Levels, level)
+	}
+	return r, nil
+}
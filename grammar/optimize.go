@@ -0,0 +1,376 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+ * Optimization passes run between Realize and Write. None of them changes what
+ * the parser accepts or the trees it builds:
+ *
+ * captures: a group in parentheses is only a call to what's inside it
+ * flatten:  a | (b | c) is a | b | c, and a (b c) is a b c
+ * factor:   a b c | a b d is a b (c | d), for alternatives next to each other
+ * dead:     constructs the entry point never reaches are dropped
+ * inline:   a construct used once, or whose body is a single call, has its
+ *           body run right where it's called, still building its node
+ */
+
+// Pass is one optimization pass.
+type Pass struct {
+	Name string
+	// Doc is what the pass does, for the command line.
+	Doc string
+	run func(constructs []Construct) []Construct
+}
+
+// Passes lists the optimization passes in the order they run.
+var Passes = []Pass{
+	{"captures", "Replace groups in parentheses by what they hold.", inlineCaptures},
+	{"flatten", "Merge chains into chains and alternatives into alternatives.", flattenAll},
+	{"factor", "Pull the common prefix out of neighbouring alternatives.", factorAll},
+	{"dead", "Drop the constructs the entry point never reaches.", dropDead},
+	{"inline", "Run the body of single use and trivial constructs where they're called.", markInlined},
+}
+
+// optimize runs every pass not in skip. With dump set, the constructs are
+// printed to it after Realize and after each pass.
+func optimize(constructs []Construct, skip map[string]bool, dump io.Writer) []Construct {
+	if dump != nil {
+		dumpIR(dump, "realize", constructs)
+	}
+	for _, p := range Passes {
+		if skip[p.Name] {
+			continue
+		}
+		constructs = p.run(constructs)
+		if dump != nil {
+			dumpIR(dump, p.Name, constructs)
+		}
+	}
+	return constructs
+}
+
+// rewrite rebuilds t bottom up, replacing every sub-expression e by f(e).
+func rewrite(t Transpilable, f func(Transpilable) Transpilable) Transpilable {
+	switch r := t.(type) {
+	case *ChainRegex:
+		for i := range r.Chain {
+			r.Chain[i] = rewrite(r.Chain[i], f)
+		}
+	case *OrRegex:
+		for i := range r.Chain {
+			r.Chain[i] = rewrite(r.Chain[i], f)
+		}
+	case *CapturedRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *MultiplierRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *OptionalRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *RepeatRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *FieldRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *PredicateRegex:
+		r.Inner = rewrite(r.Inner, f)
+	case *SeparatedRegex:
+		r.Item = rewrite(r.Item, f)
+		r.Separator = rewrite(r.Separator, f)
+	case *LeftRecursiveRegex:
+		r.Base = rewrite(r.Base, f)
+		for i := range r.Tails {
+			r.Tails[i].Tail = rewrite(r.Tails[i].Tail, f)
+		}
+	case *OperatorRegex:
+		r.Operand = rewrite(r.Operand, f)
+	}
+	return f(t)
+}
+
+// rewriteAll rewrites the body of every construct with f.
+func rewriteAll(constructs []Construct, f func(Transpilable) Transpilable) []Construct {
+	for i := range constructs {
+		constructs[i].Value = rewrite(constructs[i].Value, f)
+	}
+	return constructs
+}
+
+func inlineCaptures(constructs []Construct) []Construct {
+	return rewriteAll(constructs, func(t Transpilable) Transpilable {
+		if r, ok := t.(*CapturedRegex); ok {
+			return r.Inner
+		}
+		return t
+	})
+}
+
+func flattenAll(constructs []Construct) []Construct {
+	return rewriteAll(constructs, flatten)
+}
+
+// flatten merges the chains of a chain, or the alternatives of an
+// alternative, into it.
+func flatten(t Transpilable) Transpilable {
+	switch r := t.(type) {
+	case *ChainRegex:
+		chain := []Transpilable{}
+		for _, c := range r.Chain {
+			if inner, ok := c.(*ChainRegex); ok {
+				chain = append(chain, inner.Chain...)
+			} else {
+				chain = append(chain, c)
+			}
+		}
+		r.Chain = chain
+	case *OrRegex:
+		alts := []Transpilable{}
+		for _, c := range r.Chain {
+			if inner, ok := c.(*OrRegex); ok {
+				alts = append(alts, inner.Chain...)
+			} else {
+				alts = append(alts, c)
+			}
+		}
+		r.Chain = alts
+	}
+	return t
+}
+
+// factorAll factors every alternative, flattening the chains that leaves
+// inside chains.
+func factorAll(constructs []Construct) []Construct {
+	return rewriteAll(constructs, func(t Transpilable) Transpilable {
+		if r, ok := t.(*OrRegex); ok {
+			t = factor(r)
+		}
+		return flatten(t)
+	})
+}
+
+// factor pulls the longest common prefix out of every run of neighbouring
+// alternatives starting the same way. Only neighbours are merged, as moving
+// an alternative past another could change which one matches. Once an
+// alternative is only the prefix the ones after it in the run can't match,
+// so they're dropped, and before it the rest becomes optional.
+func factor(r *OrRegex) Transpilable {
+	alts := []Transpilable{}
+	for i := 0; i < len(r.Chain); {
+		head := symbol(elements(r.Chain[i])[0])
+		j := i + 1
+		for j < len(r.Chain) && symbol(elements(r.Chain[j])[0]) == head {
+			j++
+		}
+		if j-i == 1 {
+			alts = append(alts, r.Chain[i])
+			i = j
+			continue
+		}
+
+		run := r.Chain[i:j]
+		first := elements(run[0])
+		n := len(first)
+		for _, alt := range run[1:] {
+			es := elements(alt)
+			k := 0
+			for k < n && k < len(es) && symbol(es[k]) == symbol(first[k]) {
+				k++
+			}
+			n = k
+		}
+
+		rests := []Transpilable{}
+		optional := false
+		for _, alt := range run {
+			rest := elements(alt)[n:]
+			if len(rest) == 0 {
+				optional = true
+				break
+			}
+			rests = append(rests, chainOf(rest, r.Pos))
+		}
+
+		factored := append([]Transpilable{}, first[:n]...)
+		if len(rests) > 0 {
+			var tail Transpilable = rests[0]
+			if len(rests) > 1 {
+				tail = factor(&OrRegex{Chain: rests, Pos: r.Pos})
+			}
+			if optional {
+				tail = &OptionalRegex{Inner: tail, Pos: r.Pos}
+			}
+			factored = append(factored, tail)
+		}
+		alts = append(alts, flatten(chainOf(factored, r.Pos)))
+		i = j
+	}
+
+	if len(alts) == 1 {
+		return alts[0]
+	}
+	r.Chain = alts
+	return r
+}
+
+// elements lists what t matches one after another.
+func elements(t Transpilable) []Transpilable {
+	if r, ok := t.(*ChainRegex); ok {
+		return r.Chain
+	}
+	return []Transpilable{t}
+}
+
+func chainOf(elements []Transpilable, pos Position) Transpilable {
+	if len(elements) == 1 {
+		return elements[0]
+	}
+	return &ChainRegex{Chain: elements, Pos: pos}
+}
+
+// dropDead keeps the constructs the entry point reaches.
+func dropDead(constructs []Construct) []Construct {
+	index := map[string]int{}
+	reached := map[string]bool{}
+	queue := []string{}
+	for i, c := range constructs {
+		index[c.Name()] = i
+		if c.EntryPoint {
+			reached[c.Name()] = true
+			queue = append(queue, c.Name())
+		}
+	}
+	for len(queue) > 0 {
+		c := constructs[index[queue[0]]]
+		queue = queue[1:]
+		for _, t := range c.Value.Accumulate() {
+			if n, ok := t.(*NestedRegex); ok && !reached[n.Inner] {
+				reached[n.Inner] = true
+				queue = append(queue, n.Inner)
+			}
+		}
+	}
+
+	res := []Construct{}
+	for _, c := range constructs {
+		if reached[c.Name()] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// markInlined marks the constructs called from one place only, or whose
+// body is a single call.
+func markInlined(constructs []Construct) []Construct {
+	uses := map[string]int{}
+	for _, c := range constructs {
+		for _, t := range c.Value.Accumulate() {
+			if n, ok := t.(*NestedRegex); ok {
+				uses[n.Inner]++
+			}
+		}
+	}
+	for i, c := range constructs {
+		switch c.Value.(type) {
+		case *TokenRegex, *NestedRegex:
+			constructs[i].Inline = true
+		default:
+			constructs[i].Inline = uses[c.Name()] == 1
+		}
+	}
+	return constructs
+}
+
+// inlineCalls points every call of an inlined construct at its body. It
+// runs last, once the bodies are final.
+func inlineCalls(constructs []Construct) {
+	bodies := map[string]Transpilable{}
+	for _, c := range constructs {
+		if c.Inline {
+			bodies[c.Name()] = c.Value
+		}
+	}
+	for _, c := range constructs {
+		for _, t := range c.Value.Accumulate() {
+			if n, ok := t.(*NestedRegex); ok {
+				n.Body = bodies[n.Inner]
+			}
+		}
+	}
+}
+
+// dumpIR prints the body of every construct as a tree, under a header
+// naming the step that left it so.
+func dumpIR(w io.Writer, step string, constructs []Construct) {
+	fmt.Fprintf(w, "== %s\n", step)
+	for _, c := range constructs {
+		name := c.Name()
+		if c.Inline {
+			name += " (inlined)"
+		}
+		fmt.Fprintln(w, name)
+		dumpRegex(w, c.Value, 1)
+	}
+	fmt.Fprintln(w)
+}
+
+func dumpRegex(w io.Writer, t Transpilable, depth int) {
+	label, children := irNode(t)
+	fmt.Fprintln(w, strings.Repeat("\t", depth)+label)
+	for _, c := range children {
+		dumpRegex(w, c, depth+1)
+	}
+}
+
+// irNode labels t and lists its sub-expressions.
+func irNode(t Transpilable) (string, []Transpilable) {
+	switch r := t.(type) {
+	case *TokenRegex:
+		return "token " + r.Token.DisplayName(), nil
+	case *NestedRegex:
+		return "call " + r.Inner, nil
+	case *ChainRegex:
+		return "chain", r.Chain
+	case *OrRegex:
+		return "or", r.Chain
+	case *CapturedRegex:
+		return "group", []Transpilable{r.Inner}
+	case *MultiplierRegex:
+		if r.RequireOne {
+			return "plus", []Transpilable{r.Inner}
+		}
+		return "star", []Transpilable{r.Inner}
+	case *OptionalRegex:
+		return "optional", []Transpilable{r.Inner}
+	case *RepeatRegex:
+		return "repeat " + r.Bounds(), []Transpilable{r.Inner}
+	case *FieldRegex:
+		return "field " + r.Field, []Transpilable{r.Inner}
+	case *PredicateRegex:
+		if r.Negate {
+			return "not", []Transpilable{r.Inner}
+		}
+		return "and", []Transpilable{r.Inner}
+	case *SeparatedRegex:
+		label := "separated"
+		if r.Trailing {
+			label += " trailing"
+		}
+		if r.Empty {
+			label += " empty"
+		}
+		return label, []Transpilable{r.Item, r.Separator}
+	case *LeftRecursiveRegex:
+		children := []Transpilable{r.Base}
+		for _, tail := range r.Tails {
+			children = append(children, tail.Tail)
+		}
+		return "left recursive, base then tails", children
+	case *OperatorRegex:
+		return describe(r), nil
+	default:
+		return describe(t), nil
+	}
+}
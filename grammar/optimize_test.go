@@ -0,0 +1,94 @@
+package grammar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// passesGrammar gives every pass something to do: groups to replace, nested
+// chains and alternatives, common prefixes, a dead construct and constructs
+// to inline.
+const passesGrammar = `
+	tok ID = /[a-z]+/
+	tok NUM = /[0-9]+/
+	skip WS = /[ \t\n]+/
+	-> program = stmt*;
+	stmt = ID "=" value ";" | ID "(" args? ")" ";" | ((ID ":") (ID | NUM)) ";" | block;
+	block = "{" (stmt | (";" | ",")) * "}";
+	args = value % ",";
+	value = name:ID | number;
+	number = NUM;
+	unused = ID NUM;
+`
+
+// skipAllBut skips every pass except those named.
+func skipAllBut(names ...string) map[string]bool {
+	skip := map[string]bool{}
+	for _, p := range Passes {
+		skip[p.Name] = true
+	}
+	for _, name := range names {
+		delete(skip, name)
+	}
+	return skip
+}
+
+func TestPassesKeepParses(t *testing.T) {
+	inputs := []string{
+		"",
+		"a = 1; b = c;",
+		"f(); g(1, x, 2);",
+		"a: b; c: 1;",
+		"{ a = 1; { f(x); } ; , }",
+		"a = ;",
+		"f(1 2);",
+		"a: ;",
+		"{ a = 1;",
+		"a = 1; }",
+	}
+
+	configs := map[string]map[string]bool{"all": {}}
+	for _, p := range Passes {
+		configs[p.Name] = skipAllBut(p.Name)
+		configs["no-"+p.Name] = map[string]bool{p.Name: true}
+	}
+	want := map[string]string{}
+	none := parserFor(t, passesGrammar, Options{Skip: skipAllBut()})
+	for _, input := range inputs {
+		want[input] = sortExpected(none(input))
+	}
+	for name, skip := range configs {
+		parse := parserFor(t, passesGrammar, Options{Skip: skip})
+		for _, input := range inputs {
+			if got := sortExpected(parse(input)); got != want[input] {
+				t.Errorf("%s: %q parses to\n%s\nbut without optimizing to\n%s", name, input, got, want[input])
+			}
+		}
+	}
+}
+
+func TestPassesChangeIR(t *testing.T) {
+	_, constructs, err := load(strings.NewReader(passesGrammar), "test.chisel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump bytes.Buffer
+	optimize(constructs, nil, &dump)
+
+	// Each step, after the first, must differ from the one before it.
+	steps := strings.Split(strings.TrimPrefix(dump.String(), "== "), "\n== ")
+	if len(steps) != len(Passes)+1 {
+		t.Fatalf("dump has %d steps, want realize and %d passes:\n%s", len(steps), len(Passes), dump.String())
+	}
+	for i, p := range Passes {
+		before := steps[i][strings.Index(steps[i], "\n"):]
+		name, after, _ := strings.Cut(steps[i+1], "\n")
+		if name != p.Name {
+			t.Errorf("step %d is %q, want %q", i+1, name, p.Name)
+		}
+		if "\n"+after == before {
+			t.Errorf("%s left the IR as it was, so the grammar doesn't test it:\n%s", p.Name, after)
+		}
+	}
+}
@@ -7,10 +7,11 @@ import (
 
 type OptionalRegex struct {
 	Inner Transpilable
+	Pos   Position
 }
 
 func (r *OptionalRegex) Name() string {
-	return r.Inner.Name()
+	return mangled(symbol(r.Inner))
 }
 
 func (r *OptionalRegex) Prototype() (string, error) {
@@ -2,19 +2,21 @@ package grammar
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 type OrRegex struct {
 	Chain []Transpilable
+	Pos   Position
 }
 
 func (r *OrRegex) Name() string {
 	s := []string{}
 	for _, t := range r.Chain {
-		s = append(s, t.Name())
+		s = append(s, symbol(t))
 	}
-	return strings.Join(s, "_")
+	return mangled(s...)
 }
 
 func (r *OrRegex) Prototype() (string, error) {
@@ -27,20 +29,20 @@ func (r *OrRegex) Function() (string, error) {
 	for _, t := range r.Chain {
 		s = append(s, "res = "+t.Call("nodes")+";")
 		s = append(s, "if (res) return Result();")
-		paths = append(paths, t.Name())
+		paths = append(paths, describe(t))
 	}
 
 	return WriteString(
 		`
 		Result Lexer::or{{.Name}}(std::vector<Node> &nodes) {
 			auto {{.Innards}}
-			return Result("{{.FailReturn}}");
+			return error({{.FailReturn}});
 		}
 		`,
 		map[string]any{
 			"Name":       r.Name(),
 			"Innards":    strings.Join(s, "\n\t\t\t"),
-			"FailReturn": fmt.Sprintf("Expected match with -> (%s). All paths failed!", strings.Join(paths, " | ")),
+			"FailReturn": strconv.Quote(fmt.Sprintf("Expected match with -> (%s). All paths failed!", strings.Join(paths, " | "))),
 		},
 	)
 }
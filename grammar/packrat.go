@@ -0,0 +1,23 @@
+package grammar
+
+// memoize routes every call to a packrat construct through its memo table,
+// marking every construct packrat first if all is set.
+func memoize(constructs []Construct, all bool) {
+	packrat := map[string]bool{}
+	for i := range constructs {
+		if all {
+			constructs[i].Packrat = true
+		}
+		if constructs[i].Packrat {
+			packrat[constructs[i].Name()] = true
+		}
+	}
+
+	for i := range constructs {
+		for _, t := range constructs[i].Accumulate() {
+			if n, ok := t.(*NestedRegex); ok && packrat[n.Inner] {
+				n.Memoized = true
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package grammar
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// driver parses its first argument and prints whether it parsed cleanly, the
+// tree as an S-expression and every diagnostic.
+const driver = `#include <iostream>
+#include <sstream>
+using namespace chisel;
+int main(int argc, char **argv) {
+	std::istringstream in(argv[1]);
+	Reader reader(in);
+	Parser parser(reader);
+	auto outcome = parser.parse();
+	std::cout << "ok=" << bool(outcome) << "\n";
+	if (outcome.has_tree())
+		std::cout << to_sexp(outcome.tree()) << "\n";
+	for (auto &diagnostic : outcome.diagnostics())
+		std::cout << diagnostic.str() << "\n";
+}
+`
+
+// generate runs Chisel on grammar from the repository root, where the
+// templates are, and returns the header.
+func generate(t *testing.T, grammar string, opts Options) string {
+	t.Helper()
+	if _, err := os.Stat("util"); err != nil {
+		t.Chdir("..")
+	}
+	var out bytes.Buffer
+	if _, err := Chisel(strings.NewReader(grammar), "test.chisel", &out, ".", nil, opts); err != nil {
+		t.Fatalf("Chisel failed: %v", err)
+	}
+	return out.String()
+}
+
+// parserFor generates and compiles the parser for grammar, returning a
+// function that runs it on an input and gives what the driver printed.
+func parserFor(t *testing.T, grammar string, opts Options) func(input string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("compiling generated parsers is slow")
+	}
+	cxx, err := exec.LookPath("c++")
+	if err != nil {
+		t.Skip("no C++ compiler to build the generated parser with")
+	}
+
+	header := generate(t, grammar, opts)
+	dir := t.TempDir()
+	hpp, cpp, bin := filepath.Join(dir, "parser.hpp"), filepath.Join(dir, "main.cpp"), filepath.Join(dir, "parser")
+	if err := os.WriteFile(hpp, []byte(header), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cpp, []byte(driver), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cxx, "-std=c++20", "-include", hpp, cpp, "-o", bin).CombinedOutput(); err != nil {
+		t.Fatalf("generated parser doesn't compile: %v\n%s", err, out)
+	}
+
+	return func(input string) string {
+		out, err := exec.Command(bin, input).CombinedOutput()
+		if err != nil {
+			t.Fatalf("parser failed on %q: %v\n%s", input, err, out)
+		}
+		return string(out)
+	}
+}
+
+func TestLongestMatch(t *testing.T) {
+	parse := parserFor(t, `
+		tok ID = /[a-zA-Z_][a-zA-Z0-9_]*/
+		tok NUM = /[0-9]+/
+		skip WS = /[ \t\n]+/
+		-> program = stmt*;
+		stmt = "if" ID ";" | ID "=" NUM ";";
+	`, Options{})
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ify = 1;", "ok=1\n(program (stmt (ID \"ify\") \"=\" (NUM \"1\") \";\"))\n"},
+		{"if x;", "ok=1\n(program (stmt \"if\" (ID \"x\") \";\"))\n"},
+		{"if_ = 2; if if_;", "ok=1\n(program (stmt (ID \"if_\") \"=\" (NUM \"2\") \";\") (stmt \"if\" (ID \"if_\") \";\"))\n"},
+		{"if = 1;", "ok=0\n1:4 error: Unexpected '=' of type '\"=\"', expected 'ID'!\n"},
+	}
+	for _, tt := range tests {
+		if got := parse(tt.input); got != tt.want {
+			t.Errorf("%q parses to\n%s\nwant\n%s", tt.input, got, tt.want)
+		}
+	}
+}
+
+// sortExpected sorts the tokens each diagnostic in out expects, since the
+// modes find them in different orders.
+func sortExpected(out string) string {
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		prefix, list, ok := strings.Cut(line, "expected one of ")
+		if !ok {
+			continue
+		}
+		list = strings.TrimSuffix(list, "!")
+		tokens := strings.Split(list, ", ")
+		slices.Sort(tokens)
+		lines[i] = prefix + "expected one of " + strings.Join(tokens, ", ") + "!"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestPredictiveDiagnostics(t *testing.T) {
+	grammar := `
+		tok ID = /[a-z]+/
+		tok NUM = /[0-9]+/
+		tok SEMI = ";"
+		tok COMMA = ","
+		tok PLUS = "+"
+		tok STAR = "*"
+		skip WS = /[ \t\n]+/
+		-> program = stmt*;
+		stmt = "{" stmt* "}" | ID "=" expr SEMI | ID "(" sep(expr, COMMA, trailing) ")" SEMI | SEMI;
+		expr = operators(NUM) {
+			left 10: PLUS;
+			left 20: STAR;
+		};
+	`
+	trial := parserFor(t, grammar, Options{})
+	predictive := parserFor(t, grammar, Options{Predictive: true})
+
+	inputs := []string{
+		"}",
+		"{ a = 1; ",
+		"f(1 2);",
+		"f(1, 2 3);",
+		"f(1,",
+		"a = 1 2;",
+		"a = 1 + 2 * 3; { ; } b",
+		"{ f(1, 2,); }",
+	}
+	for _, input := range inputs {
+		if want, got := sortExpected(trial(input)), sortExpected(predictive(input)); got != want {
+			t.Errorf("%q parses predictively to\n%s\nbut by trial to\n%s", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Source is the full text of one grammar file. Positions keep a pointer to it
+// so errors can quote the offending line long after reading is done.
+type Source struct {
+	Name  string
+	Text  []byte
+	lines []int
+}
+
+func NewSource(name string, text []byte) *Source {
+	lines := []int{0}
+	for i, b := range text {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &Source{
+		Name:  name,
+		Text:  text,
+		lines: lines,
+	}
+}
+
+func (s *Source) position(offset int) Position {
+	line := sort.Search(len(s.lines), func(i int) bool { return s.lines[i] > offset }) - 1
+	return Position{
+		File:   s.Name,
+		Line:   line + 1,
+		Column: offset - s.lines[line] + 1,
+		Offset: offset,
+		source: s,
+	}
+}
+
+func (s *Source) line(n int) string {
+	if n < 1 || n > len(s.lines) {
+		return ""
+	}
+	start := s.lines[n-1]
+	end := len(s.Text)
+	if n < len(s.lines) {
+		end = s.lines[n] - 1
+	}
+	return strings.TrimSuffix(string(s.Text[start:end]), "\r")
+}
+
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+	source *Source
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Snippet returns the source line of p with a caret under the column, or an
+// empty string if the source is unknown.
+func (p Position) Snippet() string {
+	if p.source == nil || !p.IsValid() {
+		return ""
+	}
+	line := p.source.line(p.Line)
+
+	var caret strings.Builder
+	for i := 0; i < p.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	return line + "\n" + caret.String()
+}
+
+type GrammarError struct {
+	Pos Position
+	Msg string
+}
+
+// Error renders the error the way an error Diagnostic is rendered, so every
+// message chisel prints has the same file:line:column: severity: shape.
+func (e *GrammarError) Error() string {
+	return Diagnostic{Severity: ERROR, Pos: e.Pos, Msg: e.Msg}.Error()
+}
+
+func errorAt(pos Position, format string, args ...any) error {
+	return &GrammarError{
+		Pos: pos,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// SourceReader reads bytes out of a Source while keeping track of where it is.
+// It mirrors the subset of bufio.Reader the grammar lexer needs.
+type SourceReader struct {
+	source *Source
+	offset int
+	// comments holds the comments skipped since the last token was read.
+	comments []Comment
+}
+
+func NewSourceReader(source *Source) *SourceReader {
+	return &SourceReader{
+		source: source,
+	}
+}
+
+func (r *SourceReader) Pos() Position {
+	return r.source.position(r.offset)
+}
+
+func (r *SourceReader) ReadByte() (byte, error) {
+	if r.offset >= len(r.source.Text) {
+		return 0, io.EOF
+	}
+	b := r.source.Text[r.offset]
+	r.offset++
+	return b, nil
+}
+
+func (r *SourceReader) UnreadByte() error {
+	if r.offset == 0 {
+		return fmt.Errorf("UnreadByte at beginning of source!")
+	}
+	r.offset--
+	return nil
+}
+
+// Peek returns the next n bytes without consuming them. Like bufio.Reader it
+// returns io.EOF alongside a shorter slice if fewer than n bytes are left.
+func (r *SourceReader) Peek(n int) ([]byte, error) {
+	rest := r.source.Text[r.offset:]
+	if len(rest) < n {
+		return rest, io.EOF
+	}
+	return rest[:n], nil
+}
+
+func (r *SourceReader) Discard(n int) (int, error) {
+	rest := len(r.source.Text) - r.offset
+	if rest < n {
+		r.offset += rest
+		return rest, io.EOF
+	}
+	r.offset += n
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package grammar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PredicateRegex is a syntactic lookahead: &expr succeeds if expr matches
+// here, !expr if it doesn't. Neither consumes input or produces nodes.
+type PredicateRegex struct {
+	Inner  Transpilable
+	Negate bool
+	Pos    Position
+}
+
+func (r *PredicateRegex) Name() string {
+	if r.Negate {
+		return mangled("not", symbol(r.Inner))
+	}
+	return mangled("and", symbol(r.Inner))
+}
+
+func (r *PredicateRegex) Prototype() (string, error) {
+	return WriteString("Result predicate{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+func (r *PredicateRegex) Function() (string, error) {
+	// The inner expression runs against a scratch node so nothing it
+	// pushes, labels or separates reaches the real tree.
+	check := "if (!res)\n\t\t\t\treturn error(" + strconv.Quote("Expected "+describe(r.Inner)+" to follow!") + ");"
+	if r.Negate {
+		check = "if (res)\n\t\t\t\treturn error(" + strconv.Quote("Unexpected "+describe(r.Inner)+"!") + ");"
+	}
+
+	return WriteString(
+		`
+		Result Lexer::predicate{{.Name}}(std::vector<Node> &nodes) {
+			auto start = mark();
+			auto *parent = current;
+			ParseNode scratch(parent->type());
+			current = &scratch;
+			auto res = {{.InnerCall}};
+			current = parent;
+			rewind(start);
+			{{.Check}}
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":      r.Name(),
+			"InnerCall": r.Inner.Call("scratch.children()"),
+			"Check":     check,
+		},
+	)
+}
+
+func (r *PredicateRegex) Call(args ...string) string {
+	return fmt.Sprintf("predicate%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *PredicateRegex) Accumulate() []Transpilable {
+	return append([]Transpilable{r}, r.Inner.Accumulate()...)
+}
@@ -0,0 +1,206 @@
+package grammar
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+ * The generated parser normally tries the alternatives of a choice one after
+ * another. A predictive construct instead peeks at the next token and uses
+ * the FIRST sets of its choices to go straight to the ones that can match:
+ * alternatives become a SwitchRegex, repetitions a GuardedRegex and lists
+ * check for a separator before reading one. Only alternatives that share a
+ * token are still tried in turn.
+ */
+
+// predict rewrites the constructs marked predictive, or every construct if
+// all is set, to dispatch on the next token.
+func predict(tokens []Token, constructs []Construct, all bool) {
+	a := newAnalyzer(tokens, constructs)
+	a.follows(constructs)
+	for i := range constructs {
+		if all || constructs[i].Predictive {
+			constructs[i].Value = a.predictive(constructs[i].Value, a.follow[constructs[i].Name()])
+		}
+	}
+}
+
+// predictive returns t with its choices replaced by their predictive forms,
+// next being the tokens that can come right after t. FIRST sets are taken
+// before the children are rewritten, since the analyzer only knows the
+// realized node types.
+func (a *analyzer) predictive(t Transpilable, next tokenSet) Transpilable {
+	switch r := t.(type) {
+	case *PredicateRegex:
+		r.Inner = a.predictive(r.Inner, a.anything())
+	case *FieldRegex:
+		r.Inner = a.predictive(r.Inner, next)
+	case *CapturedRegex:
+		r.Inner = a.predictive(r.Inner, next)
+	case *OptionalRegex:
+		if g := a.guard(r.Inner, 0, 1, next, r.Pos); g != nil {
+			return g
+		}
+		r.Inner = a.predictive(r.Inner, next)
+	case *MultiplierRegex:
+		min := 0
+		if r.RequireOne {
+			min = 1
+		}
+		if g := a.guard(r.Inner, min, -1, next, r.Pos); g != nil {
+			return g
+		}
+		r.Inner = a.predictive(r.Inner, a.firstOf(r.Inner).with(next))
+	case *RepeatRegex:
+		if g := a.guard(r.Inner, r.Min, r.Max, next, r.Pos); g != nil {
+			return g
+		}
+		if r.Max == 1 {
+			r.Inner = a.predictive(r.Inner, next)
+		} else {
+			r.Inner = a.predictive(r.Inner, a.firstOf(r.Inner).with(next))
+		}
+	case *SeparatedRegex:
+		itemFirst, separatorFirst := a.firstOf(r.Item), a.firstOf(r.Separator)
+		// Like a guard, the list only peeks if the next token tells going
+		// on from moving on.
+		peek := !nullable(r.Item, a.nullable) && !nullable(r.Separator, a.nullable) &&
+			len(separatorFirst.intersect(next)) == 0 &&
+			(!r.Trailing || len(itemFirst.intersect(next)) == 0)
+		if peek {
+			r.ItemFirst = a.sorted(itemFirst)
+			r.SeparatorFirst = a.sorted(separatorFirst)
+		}
+		afterSeparator := itemFirst.with(nil)
+		if r.Trailing || nullable(r.Item, a.nullable) {
+			afterSeparator.union(next)
+		}
+		r.Item = a.predictive(r.Item, separatorFirst.with(next))
+		r.Separator = a.predictive(r.Separator, afterSeparator)
+	case *OperatorRegex:
+		r.Operand = a.predictive(r.Operand, infixOperators(r).with(next))
+	case *LeftRecursiveRegex:
+		afterStep := a.tailsFirst(r).with(next)
+		r.Base = a.predictive(r.Base, afterStep)
+		for i := range r.Tails {
+			r.Tails[i].Tail = a.predictive(r.Tails[i].Tail, afterStep)
+		}
+	case *ChainRegex:
+		after := next
+		for i := len(r.Chain) - 1; i >= 0; i-- {
+			c := r.Chain[i]
+			first := a.firstOf(c)
+			if nullable(c, a.nullable) {
+				first.union(after)
+			}
+			r.Chain[i] = a.predictive(c, after)
+			after = first
+		}
+	case *OrRegex:
+		return a.switchOf(r, next)
+	}
+	return t
+}
+
+// guard is the predictive form of a repetition of inner, or nil if the next
+// token can't tell whether to run inner again: when inner can match nothing,
+// or when a token can both start inner and come after the repetition. The
+// repetition then keeps trying inner and backing off.
+func (a *analyzer) guard(inner Transpilable, min, max int, next tokenSet, pos Position) *GuardedRegex {
+	if nullable(inner, a.nullable) {
+		return nil
+	}
+	first := a.firstOf(inner)
+	if min != max && len(first.intersect(next)) > 0 {
+		return nil
+	}
+	innerNext := next
+	if max != 1 {
+		innerNext = first.with(next)
+	}
+	return &GuardedRegex{
+		Inner:  a.predictive(inner, innerNext),
+		Min:    min,
+		Max:    max,
+		Tokens: a.sorted(first),
+		Pos:    pos,
+	}
+}
+
+func (a *analyzer) switchOf(r *OrRegex, next tokenSet) *SwitchRegex {
+	firsts := make([]tokenSet, len(r.Chain))
+	fallbacks := make([]bool, len(r.Chain))
+	all := tokenSet{}
+	for i, c := range r.Chain {
+		firsts[i] = a.firstOf(c)
+		fallbacks[i] = nullable(c, a.nullable) || startsRecovering(c)
+		all.union(firsts[i])
+	}
+
+	alts := make([]Transpilable, len(r.Chain))
+	for i, c := range r.Chain {
+		alts[i] = a.predictive(c, next)
+	}
+
+	// A token leads to every alternative that starts with it, and to the
+	// ones that can match nothing or recover from anything, in their
+	// original order. Tokens leading to the same alternatives share a case.
+	res := &SwitchRegex{
+		Or:       &OrRegex{Chain: alts, Pos: r.Pos},
+		Cases:    []SwitchCase{},
+		Default:  []Transpilable{},
+		Expected: a.sorted(all),
+		Pos:      r.Pos,
+	}
+	cases := map[string]int{}
+	for _, tok := range a.sorted(all) {
+		key := []string{}
+		targets := []Transpilable{}
+		for i, alt := range alts {
+			if firsts[i][tok] || fallbacks[i] {
+				key = append(key, strconv.Itoa(i))
+				targets = append(targets, alt)
+			}
+		}
+		k := strings.Join(key, ",")
+		if i, ok := cases[k]; ok {
+			res.Cases[i].Tokens = append(res.Cases[i].Tokens, tok)
+			continue
+		}
+		cases[k] = len(res.Cases)
+		res.Cases = append(res.Cases, SwitchCase{Tokens: []string{tok}, Alternatives: targets})
+	}
+	for i, alt := range alts {
+		if fallbacks[i] {
+			res.Default = append(res.Default, alt)
+		}
+	}
+	return res
+}
+
+// startsRecovering reports whether t starts with a call of a recovering construct,
+// which trying t would give the chance to recover.
+func startsRecovering(t Transpilable) bool {
+	switch r := t.(type) {
+	case *NestedRegex:
+		return r.Sync != nil
+	case *FieldRegex:
+		return startsRecovering(r.Inner)
+	case *CapturedRegex:
+		return startsRecovering(r.Inner)
+	case *ChainRegex:
+		return startsRecovering(r.Chain[0])
+	}
+	return false
+}
+
+// oneOf tests 'token' against the token types named. A failed token has a
+// type too, so it's ruled out first.
+func oneOf(names []string) string {
+	s := make([]string, len(names))
+	for i, name := range names {
+		s[i] = "token == Token::Type::" + name
+	}
+	return "token && (" + strings.Join(s, " || ") + ")"
+}
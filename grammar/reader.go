@@ -1,8 +1,10 @@
 package grammar
 
 import (
-	"bufio"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 type ReadData struct {
@@ -10,51 +12,134 @@ type ReadData struct {
 	Tokens           []Token
 	SimpleConstructs []SimpleConstruct
 	Suffixes         []string
+	// Predictive is set by a `predictive;` directive, making every construct
+	// dispatch on the next token.
+	Predictive bool
+	// Packrat is set by a `packrat;` directive, memoizing every construct.
+	Packrat    bool
+	Recoveries []Recovery
 }
 
-func Read(r io.Reader) (ReadData, error) {
-	toks := []Token{}
-	scs := []SimpleConstruct{}
-	prefixes, suffixes := []string{}, []string{}
+// Read parses a whole grammar, including every file it imports. file labels
+// positions in errors and is the base imports are resolved against.
+func Read(r io.Reader, file string) (ReadData, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return ReadData{}, err
+	}
+
+	im := &importer{
+		read: map[string]bool{},
+	}
+	data := ReadData{
+		Prefixes:         []string{},
+		Tokens:           []Token{},
+		SimpleConstructs: []SimpleConstruct{},
+		Suffixes:         []string{},
+		Recoveries:       []Recovery{},
+	}
+	if err := im.readSource(&data, NewSource(file, text), file); err != nil {
+		return ReadData{}, err
+	}
+	return data, nil
+}
+
+// importer inlines imported files where their import directive stands. Every
+// file is read once, so diamond imports don't define anything twice; name
+// clashes between different files are left to Validate.
+type importer struct {
+	// stack holds the files currently being read, outermost first, and
+	// names the paths they were reached through.
+	stack []string
+	names []string
+	read  map[string]bool
+}
 
-	gr := NewGrammarReader(bufio.NewReader(r))
+func (im *importer) readSource(data *ReadData, source *Source, file string) error {
+	key := importKey(file)
+	im.stack = append(im.stack, key)
+	im.names = append(im.names, file)
+	im.read[key] = true
+	defer func() {
+		im.stack = im.stack[:len(im.stack)-1]
+		im.names = im.names[:len(im.names)-1]
+	}()
+
+	gr := NewGrammarReader(NewSourceReader(source))
 	for {
-		gtok, err := gr.Peek()
+		gtok, err := gr.PeekDeclaration()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return ReadData{}, err
+			return err
 		}
 
 		if gtok.Type == SEMI_COLON {
+			gr.Read()
+			continue
+		}
+
+		if gtok.Type == IMPORT {
+			path, pos, err := ReadImport(gr)
+			if err != nil {
+				return err
+			}
+			if err := im.importFile(data, file, path, pos); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if gtok.Type == PREDICTIVE || gtok.Type == PACKRAT {
+			sc, directive, err := ReadAnnotated(gr)
+			if err != nil {
+				return err
+			}
+			switch directive {
+			case PREDICTIVE:
+				data.Predictive = true
+			case PACKRAT:
+				data.Packrat = true
+			default:
+				data.SimpleConstructs = append(data.SimpleConstructs, sc)
+			}
+			continue
+		}
+
+		if gtok.Type == RECOVER {
+			rec, err := ReadRecovery(gr)
+			if err != nil {
+				return err
+			}
+			data.Recoveries = append(data.Recoveries, rec)
 			continue
 		}
 
 		if gtok.Type == PREFIX {
 			prefix, err := ReadPrefix(gr)
 			if err != nil {
-				return ReadData{}, err
+				return err
 			}
-			prefixes = append(prefixes, prefix)
+			data.Prefixes = append(data.Prefixes, prefix)
 			continue
 		}
 
 		if gtok.Type == SUFFIX {
 			suffix, err := ReadSuffix(gr)
 			if err != nil {
-				return ReadData{}, err
+				return err
 			}
-			prefixes = append(prefixes, suffix)
+			data.Prefixes = append(data.Prefixes, suffix)
 			continue
 		}
 
 		if gtok.Type == TOK || gtok.Type == SKIP {
 			tok, err := ReadToken(gr)
 			if err != nil {
-				return ReadData{}, err
+				return err
 			}
-			toks = append(toks, tok)
+			data.Tokens = append(data.Tokens, tok)
 			continue
 		}
 
@@ -63,14 +148,116 @@ func Read(r io.Reader) (ReadData, error) {
 			break
 		}
 		if err != nil {
-			return ReadData{}, err
+			return err
+		}
+		data.SimpleConstructs = append(data.SimpleConstructs, sc)
+	}
+	return nil
+}
+
+func (im *importer) importFile(data *ReadData, from, path string, pos Position) error {
+	file := path
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(from), path)
+	}
+
+	key := importKey(file)
+	for i, k := range im.stack {
+		if k == key {
+			cycle := append(append([]string{}, im.names[i:]...), file)
+			return errorAt(pos, "Import cycle: %s!", strings.Join(cycle, " -> "))
 		}
-		scs = append(scs, sc)
-	}
-	return ReadData{
-		Prefixes:         prefixes,
-		Tokens:           toks,
-		SimpleConstructs: scs,
-		Suffixes:         suffixes,
-	}, nil
+	}
+	if im.read[key] {
+		return nil
+	}
+
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return errorAt(pos, "Failed to import %q: %v!", path, err)
+	}
+	return im.readSource(data, NewSource(file, text), file)
+}
+
+// importKey identifies a file no matter which relative path reached it.
+func importKey(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return filepath.Clean(file)
+}
+
+// ReadImport reads `import "path";` and returns the path as written.
+func ReadImport(gr *GrammarReader) (string, Position, error) {
+	tok, err := gr.Read()
+	if err != nil {
+		return "", Position{}, err
+	}
+	if tok.Type != IMPORT {
+		return "", Position{}, errorAt(tok.Pos, "Expected 'import', got '%s'!", tok.Value)
+	}
+
+	if tok, err = gr.ReadExpecting("a file path"); err != nil {
+		return "", Position{}, err
+	}
+	if tok.Type != STRING {
+		return "", Position{}, errorAt(tok.Pos, "Expected a string file path after 'import', got '%s'!", tok.Value)
+	}
+	path, pos := tok.Value, tok.Pos
+	if path == "" {
+		return "", Position{}, errorAt(pos, "Import path can't be empty!")
+	}
+
+	if tok, err = gr.ReadExpecting("';'"); err != nil {
+		return "", Position{}, err
+	}
+	if tok.Type != SEMI_COLON {
+		return "", Position{}, errorAt(tok.Pos, "Expected ';' after import path, got '%s'!", tok.Value)
+	}
+	return path, pos, nil
+}
+
+// ReadAnnotated reads either a directive like `packrat;`, returning its
+// keyword, or a construct with its annotations like `predictive packrat name
+// = ...;`, returning ID as the directive.
+func ReadAnnotated(gr *GrammarReader) (SimpleConstruct, GrammarTokenType, error) {
+	tok, err := gr.Read()
+	if err != nil {
+		return SimpleConstruct{}, ID, err
+	}
+	if tok.Type != PREDICTIVE && tok.Type != PACKRAT {
+		return SimpleConstruct{}, ID, errorAt(tok.Pos, "Expected 'predictive' or 'packrat', got '%s'!", tok.Value)
+	}
+
+	next, err := gr.PeekDeclaration()
+	if err == io.EOF {
+		return SimpleConstruct{}, ID, errorAt(tok.Pos, "Expected ';' or a construct after '%s'!", tok.Value)
+	}
+	if err != nil {
+		return SimpleConstruct{}, ID, err
+	}
+	if next.Type == SEMI_COLON {
+		gr.Read()
+		return SimpleConstruct{}, tok.Type, nil
+	}
+
+	var sc SimpleConstruct
+	if next.Type == PREDICTIVE || next.Type == PACKRAT {
+		var directive GrammarTokenType
+		if sc, directive, err = ReadAnnotated(gr); err != nil {
+			return SimpleConstruct{}, ID, err
+		}
+		if directive != ID {
+			return SimpleConstruct{}, ID, errorAt(next.Pos, "Expected a construct after '%s', directives take one keyword each!", tok.Value)
+		}
+	} else if sc, err = ReadSimpleConstruct(gr); err != nil {
+		return SimpleConstruct{}, ID, err
+	}
+	switch tok.Type {
+	case PREDICTIVE:
+		sc.Predictive = true
+	case PACKRAT:
+		sc.Packrat = true
+	}
+	return sc, ID, nil
 }
@@ -1,36 +1,82 @@
 package grammar
 
-import "fmt"
+import "strconv"
 
 func Realize(readData *ReadData) ([]Construct, error) {
+	if err := registerLiterals(readData); err != nil {
+		return []Construct{}, err
+	}
+
+	defined := map[string]bool{}
+	for _, tok := range readData.Tokens {
+		defined[tok.Name()] = true
+	}
+	for _, sc := range readData.SimpleConstructs {
+		defined[sc.Name] = true
+	}
+
+	sync, err := syncTokens(readData)
+	if err != nil {
+		return []Construct{}, err
+	}
+
 	cs := []Construct{}
 	for _, sc := range readData.SimpleConstructs {
-		v, err := valueOf(sc.Value, readData.Tokens, readData.SimpleConstructs)
+		var v Transpilable
+		var err error
+		if isOperatorTable(sc, func(name string) bool { return defined[name] }) {
+			v, err = parseOperatorTable(sc, readData.Tokens, readData.SimpleConstructs)
+		} else {
+			v, err = valueOf(sc, readData.Tokens, readData.SimpleConstructs)
+		}
 		if err != nil {
 			return []Construct{}, err
 		}
+		fields := fieldsOf(v)
+		if v, err = rewriteLeftRecursion(sc.Name, v, sc.Pos); err != nil {
+			return []Construct{}, err
+		}
 
 		cs = append(cs, Construct{
 			name:       sc.Name,
 			Value:      v,
 			EntryPoint: sc.EntryPoint,
+			Predictive: sc.Predictive,
+			Packrat:    sc.Packrat,
+			Sync:       sync[sc.Name],
+			Pos:        sc.Pos,
+			Fields:     fields,
 		})
 	}
+
+	if diags := leftRecursionCycles(cs); len(diags) > 0 {
+		return []Construct{}, diags
+	}
+	if diags := nullableLoops(cs); len(diags) > 0 {
+		return []Construct{}, diags
+	}
 	return cs, nil
 }
 
-func valueOf(toks []GrammarToken, tokens []Token, constructs []SimpleConstruct) (Transpilable, error) {
-	if len(toks) == 0 {
-		return nil, fmt.Errorf("Empty token list")
+func valueOf(sc SimpleConstruct, tokens []Token, constructs []SimpleConstruct) (Transpilable, error) {
+	if len(sc.Value) == 0 {
+		return nil, errorAt(sc.Pos, "Construct '%s' has an empty body!", sc.Name)
 	}
 
+	// Terminate the body with its ';' so every parse error has a token to point at.
+	toks := append(sc.Value[:len(sc.Value):len(sc.Value)], GrammarToken{
+		Type:  SEMI_COLON,
+		Value: ";",
+		Pos:   sc.End,
+	})
+
 	result, remaining, err := parseOrExpr(toks, tokens, constructs)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(remaining) > 0 {
-		return nil, fmt.Errorf("Unexpected tokens remaining")
+	if remaining[0].Type != SEMI_COLON {
+		return nil, errorAt(remaining[0].Pos, "Unexpected '%s'!", remaining[0].Value)
 	}
 	return result, nil
 }
@@ -45,7 +91,7 @@ func parseOrExpr(toks []GrammarToken, tokens []Token, constructs []SimpleConstru
 	terms = append(terms, term)
 
 	// Check for | operators
-	for len(remaining) > 0 && remaining[0].Type == PIPE {
+	for remaining[0].Type == PIPE {
 		remaining = remaining[1:] // consume |
 
 		term, newRemaining, err := parseChainExpr(remaining, tokens, constructs)
@@ -60,22 +106,22 @@ func parseOrExpr(toks []GrammarToken, tokens []Token, constructs []SimpleConstru
 		return terms[0], remaining, nil
 	}
 
-	return &OrRegex{Chain: terms}, remaining, nil
+	return &OrRegex{Chain: terms, Pos: toks[0].Pos}, remaining, nil
 }
 
 func parseChainExpr(toks []GrammarToken, tokens []Token, constructs []SimpleConstruct) (Transpilable, []GrammarToken, error) {
 	chain := []Transpilable{}
 	remaining := toks
 
-	for len(remaining) > 0 {
-		// Stop on | or ) or other terminators
-		if remaining[0].Type == PIPE || remaining[0].Type == C_PAREN {
+	for {
+		// Stop on | or ) or , or the end of the construct
+		if t := remaining[0].Type; t == PIPE || t == C_PAREN || t == COMMA || t == SEMI_COLON {
 			break
 		}
 
 		term, newRemaining, err := parsePostfixExpr(remaining, tokens, constructs)
 		if err != nil {
-			break
+			return nil, toks, err
 		}
 
 		chain = append(chain, term)
@@ -83,43 +129,66 @@ func parseChainExpr(toks []GrammarToken, tokens []Token, constructs []SimpleCons
 	}
 
 	if len(chain) == 0 {
-		return nil, toks, fmt.Errorf("Expected expression")
+		return nil, toks, errorAt(toks[0].Pos, "Expected expression, got '%s'!", toks[0].Value)
 	}
 
 	if len(chain) == 1 {
 		return chain[0], remaining, nil
 	}
 
-	return &ChainRegex{Chain: chain}, remaining, nil
+	return &ChainRegex{Chain: chain, Pos: toks[0].Pos}, remaining, nil
 }
 
 func parsePostfixExpr(toks []GrammarToken, tokens []Token, constructs []SimpleConstruct) (Transpilable, []GrammarToken, error) {
+	// Labeled expression: name:<postfix>
+	if toks[0].Type == ID && toks[1].Type == COLON {
+		inner, remaining, err := parsePostfixExpr(toks[2:], tokens, constructs)
+		if err != nil {
+			return nil, toks, err
+		}
+		return &FieldRegex{Field: toks[0].Value, Inner: inner, Pos: toks[0].Pos}, remaining, nil
+	}
+
+	// Lookahead: &<postfix> or !<postfix>
+	if toks[0].Type == AMPERSAND || toks[0].Type == BANG {
+		inner, remaining, err := parsePostfixExpr(toks[1:], tokens, constructs)
+		if err != nil {
+			return nil, toks, err
+		}
+		return &PredicateRegex{Inner: inner, Negate: toks[0].Type == BANG, Pos: toks[0].Pos}, remaining, nil
+	}
+
 	primary, remaining, err := parsePrimary(toks, tokens, constructs)
 	if err != nil {
 		return nil, toks, err
 	}
 
-	if len(remaining) == 0 {
-		return primary, remaining, nil
-	}
-
+	pos := toks[0].Pos
 	switch remaining[0].Type {
+	case PERCENT:
+		separator, rest, err := parsePrimary(remaining[1:], tokens, constructs)
+		if err != nil {
+			return nil, toks, err
+		}
+		return &SeparatedRegex{Item: primary, Separator: separator, Pos: pos}, rest, nil
 	case STAR:
-		return &MultiplierRegex{Inner: primary, RequireOne: false}, remaining[1:], nil
+		return &MultiplierRegex{Inner: primary, RequireOne: false, Pos: pos}, remaining[1:], nil
 	case PLUS:
-		return &MultiplierRegex{Inner: primary, RequireOne: true}, remaining[1:], nil
+		return &MultiplierRegex{Inner: primary, RequireOne: true, Pos: pos}, remaining[1:], nil
 	case OPTIONAL:
-		return &OptionalRegex{Inner: primary}, remaining[1:], nil
+		return &OptionalRegex{Inner: primary, Pos: pos}, remaining[1:], nil
+	case O_BRACE:
+		lo, hi, rest, err := parseRepeatBounds(remaining)
+		if err != nil {
+			return nil, toks, err
+		}
+		return &RepeatRegex{Inner: primary, Min: lo, Max: hi, Pos: pos}, rest, nil
 	default:
 		return primary, remaining, nil
 	}
 }
 
 func parsePrimary(toks []GrammarToken, tokens []Token, constructs []SimpleConstruct) (Transpilable, []GrammarToken, error) {
-	if len(toks) == 0 {
-		return nil, toks, fmt.Errorf("Unexpected end of tokens")
-	}
-
 	findToken := func(name string) *Token {
 		for _, tok := range tokens {
 			if tok.Name() == name {
@@ -128,6 +197,14 @@ func parsePrimary(toks []GrammarToken, tokens []Token, constructs []SimpleConstr
 		}
 		return nil
 	}
+	findLiteral := func(value string) *Token {
+		for _, tok := range tokens {
+			if tok.Type == LITERAL && !tok.Skip && tok.Value == value {
+				return &tok
+			}
+		}
+		return nil
+	}
 	findConstruct := func(name string) *SimpleConstruct {
 		for _, sc := range constructs {
 			if sc.Name == name {
@@ -140,19 +217,26 @@ func parsePrimary(toks []GrammarToken, tokens []Token, constructs []SimpleConstr
 
 	switch tok.Type {
 	case STRING:
-		// Token literal
-		return &TokenRegex{Token: Token{}}, toks[1:], nil
+		// Token literal, registered by registerLiterals
+		if t := findLiteral(tok.Value); t != nil {
+			return &TokenRegex{Token: *t, Pos: tok.Pos}, toks[1:], nil
+		}
+		return nil, nil, errorAt(tok.Pos, "No token found for literal %s!", strconv.Quote(tok.Value))
 
 	case ID:
 		// Check if it's a construct reference or token name
 		if c := findConstruct(tok.Value); c != nil {
-			return &NestedRegex{Inner: c.Name}, toks[1:], nil
+			return &NestedRegex{Inner: c.Name, Pos: tok.Pos}, toks[1:], nil
 		}
 		// Treat as token reference
 		if t := findToken(tok.Value); t != nil {
-			return &TokenRegex{Token: *t}, toks[1:], nil
+			return &TokenRegex{Token: *t, Pos: tok.Pos}, toks[1:], nil
+		}
+		// Built in forms, unless shadowed by a token or construct
+		if tok.Value == "sep" && toks[1].Type == O_PAREN {
+			return parseSeparated(toks, tokens, constructs)
 		}
-		return nil, nil, fmt.Errorf("Token/construct id '%s' not found!", tok.Value)
+		return nil, nil, errorAt(tok.Pos, "Token/construct id '%s' not found!", tok.Value)
 
 	case O_PAREN:
 		// Captured group: ( <expr> )
@@ -161,13 +245,13 @@ func parsePrimary(toks []GrammarToken, tokens []Token, constructs []SimpleConstr
 			return nil, toks, err
 		}
 
-		if len(remaining) == 0 || remaining[0].Type != C_PAREN {
-			return nil, toks, fmt.Errorf("Expected closing paren")
+		if remaining[0].Type != C_PAREN {
+			return nil, toks, errorAt(remaining[0].Pos, "Expected closing paren to match the one at %s, got '%s'!", tok.Pos, remaining[0].Value)
 		}
 
-		return &CapturedRegex{Inner: inner}, remaining[1:], nil
+		return &CapturedRegex{Inner: inner, Pos: tok.Pos}, remaining[1:], nil
 
 	default:
-		return nil, toks, fmt.Errorf("Unexpected token type: %v", tok.Type)
+		return nil, toks, errorAt(tok.Pos, "Unexpected '%s'!", tok.Value)
 	}
 }
@@ -0,0 +1,186 @@
+package grammar
+
+import (
+	"strconv"
+	"strings"
+)
+
+// errorNodeType is the ParseNode type recovery puts in place of a construct
+// that failed to parse.
+const errorNodeType = "Error"
+
+// Recovery is a `recover stmt until ";" | RBRACE;` declaration: when stmt
+// fails, the parser skips to one of the sync tokens and carries on.
+type Recovery struct {
+	Construct GrammarToken
+	Sync      []GrammarToken
+	Pos       Position
+}
+
+func ReadRecovery(gr *GrammarReader) (Recovery, error) {
+	tok, err := gr.Read()
+	if err != nil {
+		return Recovery{}, err
+	}
+	if tok.Type != RECOVER {
+		return Recovery{}, errorAt(tok.Pos, "Expected 'recover', got '%s'!", tok.Value)
+	}
+	pos := tok.Pos
+
+	construct, err := gr.ReadExpecting("a construct id")
+	if err != nil {
+		return Recovery{}, err
+	}
+	if construct.Type != ID {
+		return Recovery{}, errorAt(construct.Pos, "Expected a construct id after 'recover', got '%s'!", construct.Value)
+	}
+
+	if tok, err = gr.ReadExpecting("'until'"); err != nil {
+		return Recovery{}, err
+	}
+	if tok.Type != ID || tok.Value != "until" {
+		return Recovery{}, errorAt(tok.Pos, "Expected 'until' and the sync tokens after '%s', got '%s'!", construct.Value, tok.Value)
+	}
+
+	sync := []GrammarToken{}
+	for {
+		if tok, err = gr.ReadExpecting("a sync token"); err != nil {
+			return Recovery{}, err
+		}
+		if tok.Type != ID && tok.Type != STRING {
+			return Recovery{}, errorAt(tok.Pos, "Expected a token name or literal to sync on, got '%s'!", tok.Value)
+		}
+		sync = append(sync, tok)
+
+		if tok, err = gr.ReadExpecting("'|' or ';'"); err != nil {
+			return Recovery{}, err
+		}
+		if tok.Type == SEMI_COLON {
+			break
+		}
+		if tok.Type != PIPE {
+			return Recovery{}, errorAt(tok.Pos, "Expected '|' or ';' after sync token, got '%s'!", tok.Value)
+		}
+	}
+
+	return Recovery{
+		Construct: construct,
+		Sync:      sync,
+		Pos:       pos,
+	}, nil
+}
+
+// validateRecoveries checks that every recovery names a construct other than
+// the entry point, once, and syncs on declared tokens. Literals are checked
+// once they're registered as tokens.
+func validateRecoveries(readData *ReadData, isToken map[string]bool) Diagnostics {
+	diags := Diagnostics{}
+	constructs := map[string]SimpleConstruct{}
+	for _, sc := range readData.SimpleConstructs {
+		constructs[sc.Name] = sc
+	}
+
+	seen := map[string]Position{}
+	for _, rec := range readData.Recoveries {
+		name := rec.Construct.Value
+		sc, ok := constructs[name]
+		switch {
+		case isToken[name]:
+			diags.add(ERROR, rec.Construct.Pos, "Only constructs can recover, '%s' is a token!", name)
+			continue
+		case !ok:
+			diags.add(ERROR, rec.Construct.Pos, "Construct '%s' not found!", name)
+			continue
+		case sc.EntryPoint:
+			diags.add(ERROR, rec.Construct.Pos, "The entry point '%s' can't recover, recover the constructs inside it instead!", name)
+			continue
+		}
+		if prev, ok := seen[name]; ok {
+			diags.add(ERROR, rec.Pos, "Duplicate recovery for '%s', previously declared at %s!", name, prev)
+			continue
+		}
+		seen[name] = rec.Pos
+
+		for _, tok := range rec.Sync {
+			if tok.Type == ID && !isToken[tok.Value] {
+				diags.add(ERROR, tok.Pos, "Sync token '%s' not found!", tok.Value)
+			}
+		}
+	}
+
+	if len(readData.Recoveries) > 0 {
+		if sc, ok := constructs[errorNodeType]; ok {
+			diags.add(ERROR, sc.Pos, "Construct '%s' clashes with the node type error recovery inserts!", errorNodeType)
+		}
+	}
+	return diags
+}
+
+// syncTokens resolves the sync tokens of every recovering construct.
+func syncTokens(readData *ReadData) (map[string][]Token, error) {
+	res := map[string][]Token{}
+	for _, rec := range readData.Recoveries {
+		sync := []Token{}
+		for _, gtok := range rec.Sync {
+			found := false
+			for _, tok := range readData.Tokens {
+				if tok.Skip {
+					continue
+				}
+				if (gtok.Type == ID && tok.Name() == gtok.Value) || (gtok.Type == STRING && tok.Type == LITERAL && tok.Value == gtok.Value) {
+					sync = append(sync, tok)
+					found = true
+					break
+				}
+			}
+			if !found && gtok.Type == STRING {
+				return nil, errorAt(gtok.Pos, "No token found for literal %s! Sync tokens have to appear in some construct.", strconv.Quote(gtok.Value))
+			}
+			if !found {
+				return nil, errorAt(gtok.Pos, "Sync token '%s' is skipped, so it can never be seen!", gtok.Value)
+			}
+		}
+		res[rec.Construct.Value] = sync
+	}
+	return res, nil
+}
+
+// recoverable sets up every call to a recovering construct to recover. A sync
+// token that can follow the construct is left for whatever comes next, while
+// one that can't is taken as the end of the broken construct.
+func recoverable(tokens []Token, constructs []Construct) {
+	a := newAnalyzer(tokens, constructs)
+	a.follows(constructs)
+
+	for i := range constructs {
+		for _, t := range constructs[i].Accumulate() {
+			n, ok := t.(*NestedRegex)
+			if !ok {
+				continue
+			}
+			c := a.constructs[n.Inner]
+			if len(c.Sync) == 0 {
+				continue
+			}
+			n.Sync = []string{}
+			for _, tok := range c.Sync {
+				n.Sync = append(n.Sync, tok.Name())
+			}
+			n.Follow = []string{}
+			for _, name := range a.sorted(a.follow[n.Inner]) {
+				if name != endOfInput {
+					n.Follow = append(n.Follow, name)
+				}
+			}
+		}
+	}
+}
+
+// tokenTypes renders token names as a braced list of Token::Type values.
+func tokenTypes(names []string) string {
+	s := make([]string, len(names))
+	for i, name := range names {
+		s[i] = "Token::Type::" + name
+	}
+	return "{ " + strings.Join(s, ", ") + " }"
+}
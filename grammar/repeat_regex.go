@@ -0,0 +1,136 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RepeatRegex is a bounded repetition: x{3}, x{2,} or x{1,4}.
+type RepeatRegex struct {
+	Inner Transpilable
+	Min   int
+	// Max is -1 when there's no upper bound.
+	Max int
+	Pos Position
+}
+
+func (r *RepeatRegex) Name() string {
+	max := "n"
+	if r.Max >= 0 {
+		max = fmt.Sprint(r.Max)
+	}
+	return mangled(symbol(r.Inner), fmt.Sprint(r.Min), max)
+}
+
+func (r *RepeatRegex) Prototype() (string, error) {
+	return WriteString("Result repeat{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+// Bounds describes the allowed repetition count for error messages.
+func (r *RepeatRegex) Bounds() string {
+	switch {
+	case r.Min == r.Max:
+		return fmt.Sprintf("exactly %d", r.Min)
+	case r.Max < 0:
+		return fmt.Sprintf("at least %d", r.Min)
+	default:
+		return fmt.Sprintf("between %d and %d", r.Min, r.Max)
+	}
+}
+
+func (r *RepeatRegex) Function() (string, error) {
+	cond := "true"
+	if r.Max >= 0 {
+		cond = fmt.Sprintf("count < %d", r.Max)
+	}
+
+	return WriteString(
+		`
+		Result Lexer::repeat{{.Name}}(std::vector<Node> &nodes) {
+			auto start = checkpoint(nodes);
+			int count = 0;
+			while ({{.Cond}}) {
+				auto before = mark();
+				if (!{{.InnerCall}})
+					break;
+				++count;
+				if (count >= {{.Min}} && !advanced(before))
+					break;
+			}
+			if (count < {{.Min}}) {
+				std::stringstream ss;
+				ss << "Expected {{.Bounds}} repetitions, got " << count << ".";
+				auto res = error(ss.str());
+				rollback(start, nodes);
+				return res;
+			}
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":      r.Name(),
+			"Cond":      cond,
+			"Min":       r.Min,
+			"Bounds":    r.Bounds(),
+			"InnerCall": r.Inner.Call("nodes"),
+		},
+	)
+}
+
+func (r *RepeatRegex) Call(args ...string) string {
+	return fmt.Sprintf("repeat%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *RepeatRegex) Accumulate() []Transpilable {
+	return append([]Transpilable{r}, r.Inner.Accumulate()...)
+}
+
+// parseRepeatBounds parses the {m}, {m,} or {m,n} following an expression.
+// toks starts at the '{'.
+func parseRepeatBounds(toks []GrammarToken) (int, int, []GrammarToken, error) {
+	open := toks[0]
+	readInt := func(tok GrammarToken) (int, error) {
+		if tok.Type != INT {
+			return 0, errorAt(tok.Pos, "Expected a repetition count, got '%s'!", tok.Value)
+		}
+		var n int
+		if _, err := fmt.Sscan(tok.Value, &n); err != nil || n < 0 {
+			return 0, errorAt(tok.Pos, "Repetition counts must be non-negative integers, got '%s'!", tok.Value)
+		}
+		return n, nil
+	}
+	closeBrace := func(tok GrammarToken) error {
+		if tok.Type != C_BRACE {
+			return errorAt(tok.Pos, "Expected '}' to close the repetition started at %s, got '%s'!", open.Pos, tok.Value)
+		}
+		return nil
+	}
+
+	min, err := readInt(toks[1])
+	if err != nil {
+		return 0, 0, toks, err
+	}
+	max := min
+	rest := toks[2:]
+	if rest[0].Type == COMMA {
+		max = -1
+		rest = rest[1:]
+		if rest[0].Type != C_BRACE {
+			if max, err = readInt(rest[0]); err != nil {
+				return 0, 0, toks, err
+			}
+			rest = rest[1:]
+		}
+	}
+	if err := closeBrace(rest[0]); err != nil {
+		return 0, 0, toks, err
+	}
+
+	if max == 0 {
+		return 0, 0, toks, errorAt(open.Pos, "A repetition of at most 0 never matches anything!")
+	}
+	if max >= 0 && min > max {
+		return 0, 0, toks, errorAt(open.Pos, "Repetition minimum %d is larger than the maximum %d!", min, max)
+	}
+	return min, max, rest[1:], nil
+}
@@ -0,0 +1,177 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SeparatedRegex matches items separated by a delimiter: item % COMMA or
+// sep(item, COMMA, trailing, empty). Only the items end up in the node list,
+// the separators are kept on the enclosing ParseNode.
+type SeparatedRegex struct {
+	Item      Transpilable
+	Separator Transpilable
+	// Trailing allows one separator after the last item.
+	Trailing bool
+	// Empty allows the list to have no items at all.
+	Empty bool
+	// ItemFirst and SeparatorFirst are the FIRST sets of Item and Separator
+	// in predictive constructs, where the list only goes on if the next
+	// token can start what comes next.
+	ItemFirst      []string
+	SeparatorFirst []string
+	Pos            Position
+}
+
+// predicted reports whether the list peeks before going on.
+func (r *SeparatedRegex) predicted() bool {
+	return len(r.ItemFirst) > 0
+}
+
+func (r *SeparatedRegex) Name() string {
+	parts := []string{symbol(r.Item), symbol(r.Separator)}
+	if r.Trailing {
+		parts = append(parts, "trailing")
+	}
+	if r.Empty {
+		parts = append(parts, "empty")
+	}
+	if r.predicted() {
+		parts = append(parts, "predicted")
+	}
+	return mangled(parts...)
+}
+
+func (r *SeparatedRegex) Prototype() (string, error) {
+	return WriteString("Result sep{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+func (r *SeparatedRegex) Function() (string, error) {
+	first := "return res;"
+	if r.Empty {
+		first = "return {};"
+	}
+	// Like item (SEP item)*, a separator without an item after it is left
+	// for whatever comes next.
+	dangling := "rollback(before, nodes);\n\t\t\t\t\tbreak;"
+	if r.Trailing {
+		dangling = "current->separate(std::move(separator));\n\t\t\t\t\tbreak;"
+	}
+
+	return WriteString(
+		`
+		Result Lexer::sep{{.Name}}(std::vector<Node> &nodes) {
+			{{- if .Predicted}}
+			Token token;
+			{{- end}}
+			{{- if and .Predicted .Empty}}
+			token = peek();
+			if (!({{.ItemMatch}})) {
+				expect({{.ItemExpected}});
+				return {};
+			}
+			{{- end}}
+			auto res = {{.ItemCall}};
+			if (!res)
+				{{.First}}
+			while (true) {
+				{{- if .Predicted}}
+				token = peek();
+				if (!({{.SeparatorMatch}})) {
+					expect({{.SeparatorExpected}});
+					break;
+				}
+				{{- end}}
+				auto before = checkpoint(nodes);
+				std::vector<Node> separator;
+				if (!{{.SeparatorCall}})
+					break;
+				{{- if and .Predicted .Trailing}}
+				token = peek();
+				if (!({{.ItemMatch}})) {
+					expect({{.ItemExpected}});
+					{{.Dangling}}
+				}
+				{{- end}}
+				res = {{.ItemCall}};
+				if (!res) {
+					{{.Dangling}}
+				}
+				current->separate(std::move(separator));
+				if (!advanced(before.mark))
+					break;
+			}
+			return {};
+		}
+		`,
+		map[string]any{
+			"Name":              r.Name(),
+			"ItemCall":          r.Item.Call("nodes"),
+			"SeparatorCall":     r.Separator.Call("separator"),
+			"First":             first,
+			"Dangling":          dangling,
+			"Empty":             r.Empty,
+			"Trailing":          r.Trailing,
+			"Predicted":         r.predicted(),
+			"ItemMatch":         oneOf(r.ItemFirst),
+			"SeparatorMatch":    oneOf(r.SeparatorFirst),
+			"ItemExpected":      tokenTypes(r.ItemFirst),
+			"SeparatorExpected": tokenTypes(r.SeparatorFirst),
+		},
+	)
+}
+
+func (r *SeparatedRegex) Call(args ...string) string {
+	return fmt.Sprintf("sep%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *SeparatedRegex) Accumulate() []Transpilable {
+	c := []Transpilable{r}
+	c = append(c, r.Item.Accumulate()...)
+	return append(c, r.Separator.Accumulate()...)
+}
+
+// separatedOptions are the flags sep(item, SEP, ...) accepts after the
+// separator.
+var separatedOptions = map[string]bool{
+	"trailing": true,
+	"empty":    true,
+}
+
+// parseSeparated parses sep(item, SEP [, trailing] [, empty]). toks starts at
+// the 'sep'.
+func parseSeparated(toks []GrammarToken, tokens []Token, constructs []SimpleConstruct) (Transpilable, []GrammarToken, error) {
+	open := toks[1]
+	item, remaining, err := parseOrExpr(toks[2:], tokens, constructs)
+	if err != nil {
+		return nil, toks, err
+	}
+	if remaining[0].Type != COMMA {
+		return nil, toks, errorAt(remaining[0].Pos, "Expected ',' and a separator after the item of 'sep', got '%s'!", remaining[0].Value)
+	}
+
+	separator, remaining, err := parseOrExpr(remaining[1:], tokens, constructs)
+	if err != nil {
+		return nil, toks, err
+	}
+
+	r := &SeparatedRegex{Item: item, Separator: separator, Pos: toks[0].Pos}
+	for remaining[0].Type == COMMA {
+		option := remaining[1]
+		if option.Type != ID || !separatedOptions[option.Value] {
+			return nil, toks, errorAt(option.Pos, "Expected 'trailing' or 'empty', got '%s'!", option.Value)
+		}
+		switch option.Value {
+		case "trailing":
+			r.Trailing = true
+		case "empty":
+			r.Empty = true
+		}
+		remaining = remaining[2:]
+	}
+
+	if remaining[0].Type != C_PAREN {
+		return nil, toks, errorAt(remaining[0].Pos, "Expected closing paren to match the one at %s, got '%s'!", open.Pos, remaining[0].Value)
+	}
+	return r, remaining[1:], nil
+}
@@ -1,14 +1,24 @@
 package grammar
 
 import (
-	"fmt"
 	"io"
+	"strings"
+	"unicode"
 )
 
 type SimpleConstruct struct {
 	Name       string
 	Value      []GrammarToken
 	EntryPoint bool
+	// Params holds the ID tokens naming the parameters of a template like
+	// `list<X, SEP> = ...`. It's empty for ordinary constructs.
+	Params []GrammarToken
+	// Predictive and Packrat mark constructs declared with those keywords,
+	// e.g. `predictive packrat name = ...;`.
+	Predictive bool
+	Packrat    bool
+	Pos        Position
+	End        Position
 }
 
 func ReadSimpleConstruct(r *GrammarReader) (SimpleConstruct, error) {
@@ -19,49 +29,248 @@ func ReadSimpleConstruct(r *GrammarReader) (SimpleConstruct, error) {
 	entry := false
 	if tok.Type == ARROW {
 		entry = true
-		if tok, err = r.Read(); err != nil {
+		if tok, err = r.ReadExpecting("a construct id"); err != nil {
 			return SimpleConstruct{}, err
 		}
 	}
 	if tok.Type != ID {
-		return SimpleConstruct{}, fmt.Errorf("Expected ID to start construct, got '%s'!", tok.Value)
+		return SimpleConstruct{}, errorAt(tok.Pos, "Expected ID to start construct, got '%s'!", tok.Value)
 	}
 	name := tok.Value
+	pos := tok.Pos
 
-	if tok, err = r.Read(); err != nil {
+	if tok, err = r.ReadExpecting("'='"); err != nil {
 		return SimpleConstruct{}, err
 	}
+	params := []GrammarToken{}
+	if tok.Type == O_ANGLE {
+		if entry {
+			return SimpleConstruct{}, errorAt(tok.Pos, "The entry point '%s' can't take parameters!", name)
+		}
+		if params, err = readParams(r); err != nil {
+			return SimpleConstruct{}, err
+		}
+		if tok, err = r.ReadExpecting("'='"); err != nil {
+			return SimpleConstruct{}, err
+		}
+	}
 	if tok.Type != EQ {
-		return SimpleConstruct{}, fmt.Errorf("Expected '=' after construct name, got '%s'!", tok.Value)
+		return SimpleConstruct{}, errorAt(tok.Pos, "Expected '=' after construct name, got '%s'!", tok.Value)
 	}
 
+	// An operator table keeps its entries between braces, each ending in a
+	// ';' that doesn't end the construct.
 	values := []GrammarToken{}
+	braces := []GrammarToken{}
+	var end Position
 	for {
 		tok, err = r.Read()
 		if err == io.EOF {
+			if len(braces) > 0 {
+				open := braces[len(braces)-1]
+				return SimpleConstruct{}, errorAt(open.Pos, "Unclosed '{' in construct '%s'!", name)
+			}
+			end = r.Pos()
 			break
 		}
 		if err != nil {
 			return SimpleConstruct{}, err
 		}
-		if tok.Type == SEMI_COLON {
+		if tok.Type == SEMI_COLON && len(braces) == 0 {
+			end = tok.Pos
 			break
 		}
+		switch tok.Type {
+		case O_BRACE:
+			braces = append(braces, tok)
+		case C_BRACE:
+			if len(braces) > 0 {
+				braces = braces[:len(braces)-1]
+			}
+		}
 
 		values = append(values, tok)
 	}
-	if err := validateConstructValue(values); err != nil {
-		return SimpleConstruct{}, err
-	}
 
 	return SimpleConstruct{
 		Name:       name,
 		Value:      values,
 		EntryPoint: entry,
+		Params:     params,
+		Pos:        pos,
+		End:        end,
 	}, nil
 }
 
-func validateConstructValue([]GrammarToken) error {
-	// TODO: implement (return error on fail)
-	return nil
+// readParams reads the parameter list of a template after its '<'.
+func readParams(r *GrammarReader) ([]GrammarToken, error) {
+	params := []GrammarToken{}
+	seen := map[string]bool{}
+	for {
+		tok, err := r.ReadExpecting("a parameter id")
+		if err != nil {
+			return nil, err
+		}
+		if tok.Type != ID {
+			return nil, errorAt(tok.Pos, "Expected a parameter id, got '%s'!", tok.Value)
+		}
+		if seen[tok.Value] {
+			return nil, errorAt(tok.Pos, "Duplicate parameter '%s'!", tok.Value)
+		}
+		seen[tok.Value] = true
+		params = append(params, tok)
+
+		if tok, err = r.ReadExpecting("',' or '>'"); err != nil {
+			return nil, err
+		}
+		if tok.Type == C_ANGLE {
+			return params, nil
+		}
+		if tok.Type != COMMA {
+			return nil, errorAt(tok.Pos, "Expected ',' or '>' after parameter, got '%s'!", tok.Value)
+		}
+	}
+}
+
+// validateConstructValue checks the body of a single construct: every id must
+// name a known token or construct (or label a field, or call a built in form
+// like sep) and no alternative may be empty.
+func validateConstructValue(sc SimpleConstruct, defined map[string]bool) Diagnostics {
+	diags := Diagnostics{}
+	if len(sc.Value) == 0 {
+		diags.add(ERROR, sc.Pos, "Construct '%s' has an empty body!", sc.Name)
+		return diags
+	}
+
+	// One entry per open group. filled turns true once the current
+	// alternative (or argument of a built in) has something in it.
+	type group struct {
+		filled  bool
+		builtin string
+		arg     int
+	}
+	groups := []group{{}}
+	builtin := ""
+	// repeat is set inside the braces of a bounded repetition, whose ','
+	// separates its counts.
+	repeat := false
+	part := func(g group, tok GrammarToken) string {
+		if tok.Type == COMMA || (tok.Type == C_PAREN && g.builtin != "") {
+			return "argument"
+		}
+		return "alternative"
+	}
+	for i, tok := range sc.Value {
+		top := &groups[len(groups)-1]
+		switch tok.Type {
+		case ID:
+			next := GrammarToken{}
+			if i+1 < len(sc.Value) {
+				next = sc.Value[i+1]
+			}
+			if next.Type == COLON {
+				validateFieldName(sc.Name, tok, &diags)
+				continue
+			}
+			top.filled = true
+			if defined[tok.Value] {
+				continue
+			}
+			if tok.Value == "operators" && next.Type == O_PAREN {
+				diags.add(ERROR, tok.Pos, "'operators' must make up the whole body of a construct!")
+				continue
+			}
+			if tok.Value == "sep" && next.Type == O_PAREN {
+				builtin = tok.Value
+				continue
+			}
+			if top.builtin == "sep" && top.arg >= 2 {
+				if !separatedOptions[tok.Value] {
+					diags.add(ERROR, tok.Pos, "Expected 'trailing' or 'empty', got '%s'!", tok.Value)
+				}
+				continue
+			}
+			diags.add(ERROR, tok.Pos, "Token/construct id '%s' not found!", tok.Value)
+		case O_PAREN:
+			top.filled = true
+			groups = append(groups, group{builtin: builtin})
+			builtin = ""
+		case C_PAREN:
+			if len(groups) == 1 {
+				continue
+			}
+			if !top.filled {
+				diags.add(ERROR, tok.Pos, "Empty %s in construct '%s'!", part(*top, tok), sc.Name)
+			}
+			groups = groups[:len(groups)-1]
+		case COLON:
+			if i == 0 || sc.Value[i-1].Type != ID {
+				diags.add(ERROR, tok.Pos, "Expected a field name before ':'!")
+			}
+		case O_BRACE:
+			repeat = i+1 < len(sc.Value) && sc.Value[i+1].Type == INT
+			top.filled = true
+		case C_BRACE:
+			repeat = false
+			top.filled = true
+		case PIPE, COMMA:
+			if tok.Type == COMMA && repeat {
+				continue
+			}
+			if tok.Type == COMMA && top.builtin == "" {
+				diags.add(ERROR, tok.Pos, "Unexpected ','!")
+			}
+			if !top.filled {
+				diags.add(ERROR, tok.Pos, "Empty %s in construct '%s'!", part(*top, tok), sc.Name)
+			}
+			if tok.Type == COMMA {
+				top.arg++
+			}
+			top.filled = false
+		default:
+			top.filled = true
+		}
+	}
+	if !groups[len(groups)-1].filled {
+		diags.add(ERROR, sc.End, "Empty alternative in construct '%s'!", sc.Name)
+	}
+	return diags
+}
+
+// cppKeywords are the C++20 keywords and alternative operator spellings, which
+// can't name the accessor and enum value a field becomes.
+var cppKeywords = map[string]bool{
+	"alignas": true, "alignof": true, "and": true, "and_eq": true, "asm": true,
+	"auto": true, "bitand": true, "bitor": true, "bool": true, "break": true, "case": true,
+	"catch": true, "char": true, "char8_t": true, "char16_t": true, "char32_t": true,
+	"class": true, "compl": true, "concept": true, "const": true, "consteval": true,
+	"constexpr": true, "constinit": true, "const_cast": true, "continue": true,
+	"co_await": true, "co_return": true, "co_yield": true, "decltype": true,
+	"default": true, "delete": true, "do": true, "double": true, "dynamic_cast": true,
+	"else": true, "enum": true, "explicit": true, "export": true, "extern": true,
+	"false": true, "float": true, "for": true, "friend": true, "goto": true, "if": true,
+	"inline": true, "int": true, "long": true, "mutable": true, "namespace": true,
+	"new": true, "noexcept": true, "not": true, "not_eq": true, "nullptr": true,
+	"operator": true, "or": true, "or_eq": true, "private": true, "protected": true,
+	"public": true, "register": true, "reinterpret_cast": true, "requires": true,
+	"return": true, "short": true, "signed": true, "sizeof": true, "static": true,
+	"static_assert": true, "static_cast": true, "struct": true, "switch": true,
+	"template": true, "this": true, "thread_local": true, "throw": true, "true": true,
+	"try": true, "typedef": true, "typeid": true, "typename": true, "union": true,
+	"unsigned": true, "using": true, "virtual": true, "void": true, "volatile": true,
+	"wchar_t": true, "while": true, "xor": true, "xor_eq": true,
+}
+
+// validateFieldName checks that the label tok, used in the construct named
+// construct, makes a valid member of the generated <construct>Fields struct.
+func validateFieldName(construct string, tok GrammarToken, diags *Diagnostics) {
+	name := tok.Value
+	switch {
+	case cppKeywords[name]:
+		diags.add(ERROR, tok.Pos, "'%s' is a C++ keyword and can't be used as a field name!", name)
+	case strings.Contains(name, "__") || (len(name) > 1 && name[0] == '_' && unicode.IsUpper(rune(name[1]))):
+		diags.add(ERROR, tok.Pos, "'%s' is reserved in C++ and can't be used as a field name!", name)
+	case name == "_node" || name == construct+"Fields":
+		diags.add(ERROR, tok.Pos, "'%s' clashes with a member of the generated '%sFields' and can't be used as a field name!", name, construct)
+	}
 }
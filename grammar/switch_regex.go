@@ -0,0 +1,111 @@
+package grammar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SwitchRegex is the predictive form of an OrRegex. It switches on the type
+// of the next token, and only tries alternatives in turn where several of
+// them can start with that token.
+type SwitchRegex struct {
+	// Or holds the alternatives, already in predictive form.
+	Or    *OrRegex
+	Cases []SwitchCase
+	// Default lists the alternatives that can match nothing or start with
+	// a recovering construct, tried when the next token starts none of the
+	// others.
+	Default []Transpilable
+	// Expected holds the tokens any alternative can start with.
+	Expected []string
+	Pos      Position
+}
+
+// SwitchCase sends every token in Tokens to Alternatives.
+type SwitchCase struct {
+	Tokens       []string
+	Alternatives []Transpilable
+}
+
+func (r *SwitchRegex) Name() string {
+	return mangled(symbol(r.Or))
+}
+
+func (r *SwitchRegex) Prototype() (string, error) {
+	return WriteString("Result predict{{.Name}}(std::vector<Node> &);", map[string]any{"Name": r.Name()})
+}
+
+// attempt runs alts in order and returns on the first one that matches.
+func (r *SwitchRegex) attempt(alts []Transpilable, indent string) string {
+	if len(alts) == 1 {
+		return indent + "return " + alts[0].Call("nodes") + ";\n"
+	}
+
+	var s strings.Builder
+	paths := []string{}
+	for _, alt := range alts {
+		s.WriteString(indent + "res = " + alt.Call("nodes") + ";\n")
+		s.WriteString(indent + "if (res) return Result();\n")
+		paths = append(paths, describe(alt))
+	}
+	msg := fmt.Sprintf("Expected match with -> (%s). All paths failed!", strings.Join(paths, " | "))
+	s.WriteString(indent + "return error(" + strconv.Quote(msg) + ");\n")
+	return s.String()
+}
+
+func (r *SwitchRegex) Function() (string, error) {
+	var cases strings.Builder
+	for _, c := range r.Cases {
+		for _, tok := range c.Tokens {
+			cases.WriteString("\t\t\t\tcase Token::Type::" + tok + ":\n")
+		}
+		cases.WriteString(r.attempt(c.Alternatives, "\t\t\t\t\t"))
+	}
+
+	fallback := "\t\t\tskip();\n\t\t\tauto start = mark();\n\t\t\treturn unexpected(start, lex(), " + tokenTypes(r.Expected) + ");\n"
+	if len(r.Default) > 0 {
+		fallback = r.attempt(r.Default, "\t\t\t")
+	}
+
+	// Only alternatives tried in turn need somewhere to keep their result.
+	tried := len(r.Default) > 1
+	for _, c := range r.Cases {
+		tried = tried || len(c.Alternatives) > 1
+	}
+
+	return WriteString(
+		`
+		Result Lexer::predict{{.Name}}(std::vector<Node> &nodes) {
+			{{- if .Tried}}
+			Result res;
+			{{- end}}
+			auto token = peek();
+			if (token) {
+				switch (token.type()) {
+{{.Cases}}				default:
+					break;
+				}
+			}
+{{.Fallback}}		}
+		`,
+		map[string]any{
+			"Name":     r.Name(),
+			"Cases":    cases.String(),
+			"Fallback": fallback,
+			"Tried":    tried,
+		},
+	)
+}
+
+func (r *SwitchRegex) Call(args ...string) string {
+	return fmt.Sprintf("predict%s(%s)", r.Name(), strings.Join(args, ","))
+}
+
+func (r *SwitchRegex) Accumulate() []Transpilable {
+	c := []Transpilable{r}
+	for _, t := range r.Or.Chain {
+		c = append(c, t.Accumulate()...)
+	}
+	return c
+}
@@ -0,0 +1,278 @@
+package grammar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Templates are constructs with parameters:
+ *
+ * list<X, SEP> = X (SEP X)*;
+ * args = list<expr, COMMA>;
+ *
+ * Expand replaces every instantiation with a reference to a concrete construct
+ * named after the template and its arguments (list_4expr_5COMMA), creating it
+ * the first time those arguments are seen. Templates themselves never reach
+ * Validate or Realize.
+ */
+
+// maxTemplateDepth bounds how deeply instantiations may create further
+// instantiations, which catches templates that recurse with ever growing
+// arguments.
+const maxTemplateDepth = 8
+
+type templateInstance struct {
+	sc    SimpleConstruct
+	depth int
+}
+
+type templateExpander struct {
+	templates map[string]SimpleConstruct
+	// names maps the canonical form of an instantiation to its construct,
+	// keys maps the construct back, so two instantiations can never end up
+	// sharing a mangled name.
+	names map[string]string
+	keys  map[string]string
+	// defined holds the tokens and concrete constructs of the grammar, which
+	// instances must not be named like.
+	defined map[string]Position
+	queue   []templateInstance
+	diags   Diagnostics
+}
+
+// Expand instantiates the templates of a grammar, leaving only concrete
+// constructs in readData.
+func Expand(readData *ReadData) Diagnostics {
+	e := &templateExpander{
+		templates: map[string]SimpleConstruct{},
+		names:     map[string]string{},
+		keys:      map[string]string{},
+		defined:   map[string]Position{},
+	}
+
+	defined := e.defined
+	for _, tok := range readData.Tokens {
+		defined[tok.Name()] = tok.Pos
+	}
+	concrete := []SimpleConstruct{}
+	for _, sc := range readData.SimpleConstructs {
+		if len(sc.Params) == 0 {
+			concrete = append(concrete, sc)
+			if _, ok := defined[sc.Name]; !ok {
+				defined[sc.Name] = sc.Pos
+			}
+			continue
+		}
+		if prev, ok := e.templates[sc.Name]; ok {
+			e.diags.add(ERROR, sc.Pos, "Duplicate template '%s', previously defined at %s!", sc.Name, prev.Pos)
+			continue
+		}
+		e.templates[sc.Name] = sc
+	}
+	for name, tmpl := range e.templates {
+		if prev, ok := defined[name]; ok {
+			e.diags.add(ERROR, tmpl.Pos, "Template '%s' clashes with the definition at %s!", name, prev)
+		}
+	}
+	if len(e.diags) > 0 {
+		e.diags.Sort()
+		return e.diags
+	}
+
+	res := []SimpleConstruct{}
+	for _, sc := range concrete {
+		sc.Value = e.expand(sc.Value, 0)
+		res = append(res, sc)
+	}
+	for len(e.queue) > 0 {
+		inst := e.queue[0]
+		e.queue = e.queue[1:]
+		inst.sc.Value = e.expand(inst.sc.Value, inst.depth)
+		res = append(res, inst.sc)
+	}
+	readData.SimpleConstructs = res
+
+	e.diags.Sort()
+	return e.diags
+}
+
+// expand replaces the instantiations in value, which sits depth
+// instantiations deep.
+func (e *templateExpander) expand(value []GrammarToken, depth int) []GrammarToken {
+	res := []GrammarToken{}
+	for i := 0; i < len(value); i++ {
+		tok := value[i]
+		call := tok.Type == ID && i+1 < len(value) && value[i+1].Type == O_ANGLE
+		tmpl, ok := e.templates[tok.Value]
+		if tok.Type != ID || (!call && !ok) {
+			res = append(res, tok)
+			continue
+		}
+
+		if !ok {
+			e.diags.add(ERROR, value[i+1].Pos, "'%s' is not a template, it can't take arguments!", tok.Value)
+			return value
+		}
+		if !call {
+			e.diags.add(ERROR, tok.Pos, "Template '%s' must be given arguments, like %s<...>!", tok.Value, tok.Value)
+			return value
+		}
+
+		args, end, ok := e.splitArgs(value, i+1)
+		if !ok {
+			return value
+		}
+		if len(args) != len(tmpl.Params) {
+			e.diags.add(ERROR, tok.Pos, "Template '%s' takes %d argument(s), got %d!", tmpl.Name, len(tmpl.Params), len(args))
+			return value
+		}
+		for j := range args {
+			args[j] = e.expand(args[j], depth)
+		}
+
+		name, ok := e.instantiate(tmpl, args, tok.Pos, depth+1)
+		if !ok {
+			return value
+		}
+		res = append(res, GrammarToken{
+			Type:  ID,
+			Value: name,
+			Pos:   tok.Pos,
+		})
+		i = end
+	}
+	return res
+}
+
+// instantiate returns the construct for tmpl applied to args, queueing it for
+// creation the first time.
+func (e *templateExpander) instantiate(tmpl SimpleConstruct, args [][]GrammarToken, pos Position, depth int) (string, bool) {
+	key := tmpl.Name
+	for _, arg := range args {
+		key += "\x00"
+		for _, tok := range arg {
+			key += fmt.Sprintf("%d:%s\x01", tok.Type, tok.Value)
+		}
+	}
+	if name, ok := e.names[key]; ok {
+		return name, true
+	}
+
+	if depth > maxTemplateDepth {
+		e.diags.add(ERROR, pos, "Instantiating template '%s' nests more than %d templates deep! Does it instantiate itself with growing arguments?", tmpl.Name, maxTemplateDepth)
+		return "", false
+	}
+
+	name := mangle(tmpl.Name, args)
+	for n, unique := 2, name; ; n++ {
+		if _, taken := e.keys[unique]; !taken {
+			name = unique
+			break
+		}
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	if prev, ok := e.defined[name]; ok {
+		e.diags.add(ERROR, pos, "Instantiating template '%s' here creates '%s', which clashes with the definition at %s!", tmpl.Name, name, prev)
+		return "", false
+	}
+	e.names[key] = name
+	e.keys[name] = key
+
+	params := map[string][]GrammarToken{}
+	for i, param := range tmpl.Params {
+		params[param.Value] = args[i]
+	}
+	value := []GrammarToken{}
+	for _, tok := range tmpl.Value {
+		arg, ok := params[tok.Value]
+		if tok.Type != ID || !ok {
+			value = append(value, tok)
+			continue
+		}
+		if len(arg) == 1 {
+			value = append(value, arg[0])
+			continue
+		}
+		// Group longer arguments so they bind like a single element.
+		value = append(value, GrammarToken{Type: O_PAREN, Value: "(", Pos: arg[0].Pos})
+		value = append(value, arg...)
+		value = append(value, GrammarToken{Type: C_PAREN, Value: ")", Pos: arg[len(arg)-1].Pos})
+	}
+
+	e.queue = append(e.queue, templateInstance{
+		sc: SimpleConstruct{
+			Name:  name,
+			Value: value,
+			Pos:   pos,
+			End:   tmpl.End,
+		},
+		depth: depth,
+	})
+	return name, true
+}
+
+// splitArgs splits the arguments of the instantiation whose '<' is at
+// value[open], returning them and the index of the closing '>'.
+func (e *templateExpander) splitArgs(value []GrammarToken, open int) ([][]GrammarToken, int, bool) {
+	args := [][]GrammarToken{}
+	arg := []GrammarToken{}
+	angles, parens := 0, 0
+	for i := open + 1; i < len(value); i++ {
+		tok := value[i]
+		switch tok.Type {
+		case O_ANGLE:
+			angles++
+		case C_ANGLE:
+			if angles == 0 && parens == 0 {
+				if len(arg) == 0 {
+					e.diags.add(ERROR, tok.Pos, "Empty template argument!")
+					return nil, 0, false
+				}
+				return append(args, arg), i, true
+			}
+			angles--
+		case O_PAREN:
+			parens++
+		case C_PAREN:
+			parens--
+		case COMMA:
+			if angles == 0 && parens == 0 {
+				if len(arg) == 0 {
+					e.diags.add(ERROR, tok.Pos, "Empty template argument!")
+					return nil, 0, false
+				}
+				args = append(args, arg)
+				arg = []GrammarToken{}
+				continue
+			}
+		}
+		arg = append(arg, tok)
+	}
+	e.diags.add(ERROR, value[open].Pos, "Unclosed '<', expected '>' to end the template arguments!")
+	return nil, 0, false
+}
+
+// mangle names an instantiation after its template and arguments. Every
+// argument is length prefixed so different argument lists can't collide.
+func mangle(name string, args [][]GrammarToken) string {
+	var s strings.Builder
+	s.WriteString(name)
+	for _, arg := range args {
+		parts := make([]string, len(arg))
+		for i, tok := range arg {
+			switch tok.Type {
+			case ID, INT:
+				parts[i] = tok.Value
+			case STRING:
+				parts[i] = literalName(tok.Value)
+			default:
+				parts[i] = fmt.Sprintf("%X", tok.Value)
+			}
+		}
+		part := strings.Join(parts, "_")
+		s.WriteString("_" + strconv.Itoa(len(part)) + part)
+	}
+	return s.String()
+}
@@ -0,0 +1,97 @@
+package grammar
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// expand reads src and expands its templates, returning the constructs it
+// ends up with as "name = body" lines and the diagnostics.
+func expand(t *testing.T, src string) ([]string, Diagnostics) {
+	t.Helper()
+	readData, err := Read(strings.NewReader(src), "test.chisel")
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read failed: %v", err)
+	}
+	diags := Expand(&readData)
+	constructs := []string{}
+	for _, sc := range readData.SimpleConstructs {
+		values := make([]string, len(sc.Value))
+		for i, tok := range sc.Value {
+			values[i] = tok.Value
+		}
+		constructs = append(constructs, sc.Name+" = "+strings.Join(values, " "))
+	}
+	return constructs, diags
+}
+
+func TestExpand(t *testing.T) {
+	constructs, diags := expand(t, `
+		tok ID = /[a-z]+/
+		tok COMMA = ","
+		list<X, SEP> = X (SEP X)*;
+		pair<X> = "(" X X ")";
+		-> args = list<ID, COMMA> list<ID, COMMA> list<pair<ID>, ";">;
+		nested = list<ID "=" ID, COMMA>;
+	`)
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics:\n%s", diags.Error())
+	}
+	want := []string{
+		"args = list_2ID_5COMMA list_2ID_5COMMA list_8pair_2ID_6LIT_3B",
+		"nested = list_12ID_LIT_3D_ID_5COMMA",
+		"list_2ID_5COMMA = ID ( COMMA ID ) *",
+		"pair_2ID = ( ID ID )",
+		"list_8pair_2ID_6LIT_3B = pair_2ID ( ; pair_2ID ) *",
+		"list_12ID_LIT_3D_ID_5COMMA = ( ID = ID ) ( COMMA ( ID = ID ) ) *",
+	}
+	if strings.Join(constructs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expanded to\n%s\nwant\n%s", strings.Join(constructs, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"arity",
+			"tok ID = /[a-z]+/\nlist<X, SEP> = X (SEP X)*;\n-> p = list<ID>;\n",
+			"test.chisel:3:8: error: Template 'list' takes 2 argument(s), got 1!",
+		},
+		{
+			"no arguments",
+			"tok ID = /[a-z]+/\nlist<X> = X*;\n-> p = list;\n",
+			"test.chisel:3:8: error: Template 'list' must be given arguments, like list<...>!",
+		},
+		{
+			"not a template",
+			"tok ID = /[a-z]+/\n-> p = ID<ID>;\n",
+			"test.chisel:2:10: error: 'ID' is not a template, it can't take arguments!",
+		},
+		{
+			"depth",
+			"tok ID = /[a-z]+/\ngrow<X> = X | grow<(X X)>;\n-> p = grow<ID>;\n",
+			"error: Instantiating template 'grow' nests more than 8 templates deep!",
+		},
+		{
+			"instance clash",
+			"tok ID = /[a-z]+/\nopt<X> = X?;\nopt_2ID = ID;\n-> p = opt<ID> opt_2ID;\n",
+			"test.chisel:4:8: error: Instantiating template 'opt' here creates 'opt_2ID', which clashes with the definition at test.chisel:3:1!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := expand(t, tt.src)
+			if !diags.HasErrors() {
+				t.Fatal("expanded without errors")
+			}
+			if got := diags.Filter(ERROR).Error(); !strings.Contains(got, tt.want) {
+				t.Errorf("got\n%s\nwant it to contain\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ type TokenType int
 const (
 	LITERAL TokenType = iota
 	CODE
+	PATTERN
 )
 
 type Token struct {
@@ -19,16 +20,34 @@ type Token struct {
 	Value      string
 	Skip       bool
 	Precedence int
+	Pos        Position
+
+	// Anonymous tokens were created for a string literal used inline in a
+	// construct rather than declared with 'tok'.
+	Anonymous bool
+
+	dfa *DFA
 }
 
 func (t *Token) Name() string {
 	return t.name
 }
 
+// DisplayName is the name the generated lexer uses in error messages.
+func (t *Token) DisplayName() string {
+	if t.Anonymous {
+		return strconv.Quote(t.Value)
+	}
+	return t.name
+}
+
 func (t *Token) Function() (string, error) {
 	if t.Type == LITERAL {
 		return "", nil
 	}
+	if t.Type == PATTERN {
+		return t.patternFunction()
+	}
 	if t.Skip {
 		return WriteString(
 			"void Lexer::skip{{.Name}} {{.Code}}",
@@ -47,6 +66,40 @@ func (t *Token) Function() (string, error) {
 	)
 }
 
+func (t *Token) patternFunction() (string, error) {
+	if t.Skip {
+		return WriteString(
+			`
+			void Lexer::skip{{.Name}}(std::istream &reader) {
+				{{.Matcher}}
+			}
+			`,
+			map[string]any{
+				"Name":    t.Name(),
+				"Matcher": t.dfa.Matcher(),
+			},
+		)
+	}
+	return WriteString(
+		`
+		Token Lexer::token{{.Name}}(std::istream &reader) {
+			{{.Matcher}}
+			if (accepted == 0)
+				return Token::failed;
+
+			auto *data = new TOKEN_UTILE_TYPE[accepted + 1];
+			memcpy(data, text.data(), accepted);
+			data[accepted] = '\0';
+			return Token(Token::Type::{{.Name}}, data, accepted);
+		}
+		`,
+		map[string]any{
+			"Name":    t.Name(),
+			"Matcher": t.dfa.Matcher(),
+		},
+	)
+}
+
 func (t *Token) Prototype() (string, error) {
 	if t.Type == LITERAL {
 		return "", nil
@@ -84,46 +137,54 @@ func ReadToken(r *GrammarReader) (Token, error) {
 		return Token{}, err
 	}
 	if tok.Type != TOK && tok.Type != SKIP {
-		return Token{}, fmt.Errorf("Expected token to start with 'tok' or 'skip', got '%s'!", tok.Value)
+		return Token{}, errorAt(tok.Pos, "Expected token to start with 'tok' or 'skip', got '%s'!", tok.Value)
 	}
 	skip := tok.Type == SKIP
 
-	if tok, err = r.Read(); err != nil {
+	if tok, err = r.ReadExpecting("a token id"); err != nil {
 		return Token{}, err
 	}
 	prec := 0
 	if tok.Type == INT {
 		if prec, err = strconv.Atoi(tok.Value); err != nil {
-			return Token{}, fmt.Errorf("Invalid precedence! Expected an integer, got '%s'", tok.Value)
+			return Token{}, errorAt(tok.Pos, "Invalid precedence! Expected an integer, got '%s'", tok.Value)
 		}
-		if tok, err = r.Read(); err != nil {
+		if tok, err = r.ReadExpecting("a token id"); err != nil {
 			return Token{}, err
 		}
 	}
 
 	if tok.Type != ID {
-		return Token{}, fmt.Errorf("Expected token id to follow the 'tok' token, got '%s'", tok.Value)
+		return Token{}, errorAt(tok.Pos, "Expected token id to follow the 'tok' token, got '%s'", tok.Value)
 	}
 	name := tok.Value
+	pos := tok.Pos
 
-	if tok, err = r.Read(); err != nil {
+	if tok, err = r.ReadExpecting("'='"); err != nil {
 		return Token{}, err
 	}
 	if tok.Type != EQ {
-		return Token{}, fmt.Errorf("Expected '=' to follow the token id, got '%s'", tok.Value)
+		return Token{}, errorAt(tok.Pos, "Expected '=' to follow the token id, got '%s'", tok.Value)
 	}
 
-	if tok, err = r.Read(); err != nil {
+	if tok, err = r.ReadExpecting("code, a string literal or a pattern"); err != nil {
 		return Token{}, err
 	}
-	if tok.Type != CPP_CODE && tok.Type != STRING {
-		return Token{}, fmt.Errorf("Expected either code or string literal, got '%s'", tok.Value)
+	if tok.Type != CPP_CODE && tok.Type != STRING && tok.Type != REGEX {
+		return Token{}, errorAt(tok.Pos, "Expected either code, string literal or pattern, got '%s'", tok.Value)
 	}
 	value := tok.Value
 	var t TokenType = LITERAL
+	var dfa *DFA
 
-	if tok.Type == CPP_CODE {
+	switch tok.Type {
+	case CPP_CODE:
 		t = CODE
+	case REGEX:
+		t = PATTERN
+		if dfa, err = CompileRegex(value); err != nil {
+			return Token{}, errorAt(tok.Pos, "Invalid pattern /%s/: %v", value, err)
+		}
 	}
 
 	return Token{
@@ -132,5 +193,7 @@ func ReadToken(r *GrammarReader) (Token, error) {
 		Value:      value,
 		Skip:       skip,
 		Precedence: prec,
+		Pos:        pos,
+		dfa:        dfa,
 	}, nil
 }
@@ -7,6 +7,7 @@ import (
 
 type TokenRegex struct {
 	Token Token
+	Pos   Position
 }
 
 func (r *TokenRegex) Name() string {
@@ -21,14 +22,11 @@ func (r *TokenRegex) Function() (string, error) {
 	return WriteString(
 		`
 		Result Lexer::regex{{.Name}}(std::vector<Node> &nodes) {
+			skip();
+			auto start = mark();
 			auto token = lex();
-			if (!token)
-				return error("Unknown token!");
-			else if (token != Token::Type::{{.Name}}) {
-				std::stringstream ss;
-				ss << "Invalid token! Expected '" << Token::name(Token::Type::{{.Name}}) << "', got '" << token.data() << "' of type '" << Token::name(token.type()) << "'.";
-				return error(ss.str());
-			}
+			if (!token || token != Token::Type::{{.Name}})
+				return unexpected(start, token, { Token::Type::{{.Name}} });
 			nodes.emplace_back(token);
 			return {};
 		}
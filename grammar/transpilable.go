@@ -1,6 +1,7 @@
 package grammar
 
 import (
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -23,6 +24,23 @@ type Transpilable interface {
 	Accumulate() []Transpilable
 }
 
+// symbol is the name of the function t generates.
+func symbol(t Transpilable) string {
+	return strings.TrimSuffix(t.Call(), "()")
+}
+
+// mangled names a sub-expression after its parts, each prefixed with its
+// length the way template instances are named, so different parts never
+// give the same name and the same parts always do. Parts of child
+// expressions are their symbols, which tell the kinds apart too.
+func mangled(parts ...string) string {
+	var s strings.Builder
+	for _, part := range parts {
+		s.WriteString("_" + strconv.Itoa(len(part)) + part)
+	}
+	return s.String()
+}
+
 func WriteString(text string, data any) (string, error) {
 	var s strings.Builder
 	t := template.Must(template.New("").Parse(text))
@@ -0,0 +1,102 @@
+package grammar
+
+// Validate checks a grammar for semantic problems before it is realized:
+// duplicate names, undefined references, empty alternatives, a missing or
+// repeated entry point and constructs the entry point can never reach.
+func Validate(readData *ReadData) Diagnostics {
+	diags := Diagnostics{}
+
+	defined := map[string]bool{}
+	positions := map[string]Position{}
+	for _, tok := range readData.Tokens {
+		if prev, ok := positions[tok.Name()]; ok {
+			diags.add(ERROR, tok.Pos, "Duplicate token '%s', previously defined at %s!", tok.Name(), prev)
+			continue
+		}
+		defined[tok.Name()] = true
+		positions[tok.Name()] = tok.Pos
+	}
+	for _, sc := range readData.SimpleConstructs {
+		if prev, ok := positions[sc.Name]; ok {
+			diags.add(ERROR, sc.Pos, "Duplicate construct '%s', previously defined at %s!", sc.Name, prev)
+			continue
+		}
+		defined[sc.Name] = true
+		positions[sc.Name] = sc.Pos
+	}
+
+	isToken := map[string]bool{}
+	for _, tok := range readData.Tokens {
+		isToken[tok.Name()] = true
+	}
+
+	diags = append(diags, validateRecoveries(readData, isToken)...)
+
+	var entry *SimpleConstruct
+	for i, sc := range readData.SimpleConstructs {
+		if isOperatorTable(sc, func(name string) bool { return defined[name] }) {
+			diags = append(diags, validateOperatorTable(sc, defined, isToken)...)
+		} else {
+			diags = append(diags, validateConstructValue(sc, defined)...)
+		}
+
+		if !sc.EntryPoint {
+			continue
+		}
+		if entry != nil {
+			diags.add(ERROR, sc.Pos, "Only one entry point allowed! Previous entry point was '%s' at %s.", entry.Name, entry.Pos)
+			continue
+		}
+		entry = &readData.SimpleConstructs[i]
+	}
+
+	if entry == nil {
+		var pos Position
+		if len(readData.SimpleConstructs) > 0 {
+			pos = readData.SimpleConstructs[0].Pos
+		}
+		diags.add(ERROR, pos, "No entry point found! Mark one construct with '->'.")
+		diags.Sort()
+		return diags
+	}
+
+	reachable := reachableConstructs(entry.Name, readData.SimpleConstructs)
+	for _, sc := range readData.SimpleConstructs {
+		if !reachable[sc.Name] {
+			diags.add(WARNING, sc.Pos, "Construct '%s' is not reachable from the entry point '%s'.", sc.Name, entry.Name)
+		}
+	}
+	diags.Sort()
+	return diags
+}
+
+func reachableConstructs(entry string, constructs []SimpleConstruct) map[string]bool {
+	byName := map[string]SimpleConstruct{}
+	for _, sc := range constructs {
+		if _, ok := byName[sc.Name]; !ok {
+			byName[sc.Name] = sc
+		}
+	}
+
+	reachable := map[string]bool{entry: true}
+	stack := []string{entry}
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		value := byName[name].Value
+		for i, tok := range value {
+			if tok.Type != ID || reachable[tok.Value] {
+				continue
+			}
+			if i+1 < len(value) && value[i+1].Type == COLON {
+				continue
+			}
+			if _, ok := byName[tok.Value]; ok {
+				reachable[tok.Value] = true
+				stack = append(stack, tok.Value)
+			}
+		}
+	}
+	return reachable
+}
@@ -0,0 +1,49 @@
+package grammar
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// validate reads, expands and validates src, returning every diagnostic.
+func validate(t *testing.T, src string) Diagnostics {
+	t.Helper()
+	readData, err := Read(strings.NewReader(src), "test.chisel")
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read failed: %v", err)
+	}
+	diags := Expand(&readData)
+	if diags.HasErrors() {
+		return diags
+	}
+	return append(diags, Validate(&readData)...)
+}
+
+func TestFieldNames(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"name", ""},
+		{"_name", ""},
+		{"class", "test.chisel:2:8: error: 'class' is a C++ keyword and can't be used as a field name!"},
+		{"and_eq", "test.chisel:2:8: error: 'and_eq' is a C++ keyword and can't be used as a field name!"},
+		{"_Name", "test.chisel:2:8: error: '_Name' is reserved in C++ and can't be used as a field name!"},
+		{"a__b", "test.chisel:2:8: error: 'a__b' is reserved in C++ and can't be used as a field name!"},
+		{"_node", "test.chisel:2:8: error: '_node' clashes with a member of the generated 'pFields' and can't be used as a field name!"},
+		{"pFields", "test.chisel:2:8: error: 'pFields' clashes with a member of the generated 'pFields' and can't be used as a field name!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			diags := validate(t, "tok ID = /[a-z]+/\n-> p = "+tt.label+": ID;\n")
+			got := ""
+			if diags.HasErrors() {
+				got = strings.SplitN(diags.Filter(ERROR).Error(), "\n", 2)[0]
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
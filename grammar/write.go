@@ -10,38 +10,45 @@ import (
 	"text/template"
 )
 
-func Write(w io.Writer, visitorWriter io.Writer, chiselPath string, tokens []Token, constructs []Construct) error {
+// Write generates the library for the grammar, returning how its functions
+// were shared.
+func Write(w io.Writer, visitorWriter io.Writer, chiselPath string, tokens []Token, constructs []Construct) (Stats, error) {
 	if err := writeFile(w, "util/params.hpp"); err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	if err := writeTokenHpp(w, tokens); err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	if err := writeFile(w, "util/Reader.hpp", "util/Node.hpp", "util/Result.hpp"); err != nil {
-		return err
+		return Stats{}, err
 	}
 
-	if err := writeLexerHpp(w, tokens, constructs); err != nil {
-		return err
+	if err := writeParseNodeHpp(w, constructs); err != nil {
+		return Stats{}, err
 	}
 
-	if err := writeParseNodeHpp(w, constructs); err != nil {
-		return err
+	if err := writeFile(w, "util/Dump.hpp"); err != nil {
+		return Stats{}, err
+	}
+
+	stats, err := writeLexerHpp(w, tokens, constructs)
+	if err != nil {
+		return Stats{}, err
 	}
 
 	if err := writeParserHpp(w, constructs); err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	if visitorWriter != nil {
 		if err := writeVisitorHpp(visitorWriter, chiselPath, constructs); err != nil {
-			return err
+			return Stats{}, err
 		}
 	}
 
-	return nil
+	return stats, nil
 }
 
 func writeTokenHpp(w io.Writer, tokens []Token) error {
@@ -66,7 +73,7 @@ func writeTokenHpp(w io.Writer, tokens []Token) error {
 	typeNames := make([]string, len(tokens))
 	for i, tok := range tokens {
 		tokenTypes[i] = tok.Name()
-		typeNames[i] = "\"" + tok.Name() + "\""
+		typeNames[i] = strconv.Quote(tok.DisplayName())
 		if tok.Type == LITERAL {
 			staticTypeValues[i] = strconv.Quote(tok.Value)
 			staticTypeLengths[i] = fmt.Sprintf("%d", len(tok.Value))
@@ -91,63 +98,7 @@ func writeTokenHpp(w io.Writer, tokens []Token) error {
 	return nil
 }
 
-func writeLexerHpp(w io.Writer, tokens []Token, constructs []Construct) error {
-	TokenData := func(tokens []Token) (string, string, error) {
-		var prototypes strings.Builder
-		var definitions strings.Builder
-		for _, tok := range tokens {
-			if tok.Type == LITERAL {
-				continue
-			}
-
-			p, err := tok.Prototype()
-			if err != nil {
-				return "", "", err
-			}
-
-			d, err := tok.Function()
-			if err != nil {
-				return "", "", err
-			}
-
-			if _, err := prototypes.WriteString(p + "\n"); err != nil {
-				return "", "", err
-			}
-
-			if _, err := definitions.WriteString(d + "\n"); err != nil {
-				return "", "", err
-			}
-		}
-		return prototypes.String(), definitions.String(), nil
-	}
-	RegexData := func(constructs []Construct) (string, string, error) {
-		all := []Transpilable{}
-		for _, c := range constructs {
-			all = append(all, c.Accumulate()...)
-		}
-
-		var prototypes strings.Builder
-		var definitions strings.Builder
-		for _, t := range all {
-			p, err := t.Prototype()
-			if err != nil {
-				return "", "", err
-			}
-
-			d, err := t.Function()
-			if err != nil {
-				return "", "", err
-			}
-
-			if _, err := prototypes.WriteString(p + "\n"); err != nil {
-				return "", "", err
-			}
-			if _, err := definitions.WriteString(d + "\n"); err != nil {
-				return "", "", err
-			}
-		}
-		return prototypes.String(), definitions.String(), nil
-	}
+func writeLexerHpp(w io.Writer, tokens []Token, constructs []Construct) (Stats, error) {
 	LexBody := func(token []Token) (string, error) {
 		GroupByPrecedence := func(tokens []Token) [][]Token {
 			if len(tokens) == 0 {
@@ -184,23 +135,32 @@ func writeLexerHpp(w io.Writer, tokens []Token, constructs []Construct) error {
 				}
 			}
 
+			// Within a group the longest match wins, the literals in the
+			// trie on a tie, so a keyword doesn't cut an identifier short.
+			calls := []string{}
 			if found {
-				_, err := res.WriteString(fmt.Sprintf("if (auto tok = tries[%d].search(reader); tok) { return tok; }\n", index))
-				if err != nil {
-					return "", err
-				}
+				calls = append(calls, fmt.Sprintf("tries[%d].search(reader)", index))
 				index++
 			}
-
 			for _, tok := range prec {
 				if tok.Type == LITERAL || tok.Skip {
 					continue
 				}
-				_, err := res.WriteString(fmt.Sprintf("if (auto tok = %s; tok) { return tok; }\n", tok.Call("reader")))
+				calls = append(calls, tok.Call("reader"))
+			}
+
+			if len(calls) == 1 {
+				_, err := res.WriteString(fmt.Sprintf("if (auto tok = %s; tok) { return tok; }\n", calls[0]))
 				if err != nil {
 					return "", err
 				}
+				continue
 			}
+			res.WriteString("{\n\tauto start = mark();\n\tauto end = start;\n\tToken best;\n")
+			for _, call := range calls {
+				res.WriteString(fmt.Sprintf("\tlongest(start, %s, best, end);\n", call))
+			}
+			res.WriteString("\tif (best) {\n\t\trewind(end);\n\t\treturn best;\n\t}\n}\n")
 		}
 		_, err := res.WriteString("return Token::failed;\n")
 		if err != nil {
@@ -283,34 +243,55 @@ func writeLexerHpp(w io.Writer, tokens []Token, constructs []Construct) error {
 		return s.String()
 	}
 
-	tPrototypes, tDefinitions, err := TokenData(tokens)
-	if err != nil {
-		return err
+	MemoTables := func(constructs []Construct) string {
+		tables := []string{}
+		for _, c := range constructs {
+			if c.Packrat {
+				tables = append(tables, fmt.Sprintf("std::unordered_map<std::streamoff, Memo> memo%s;", c.Name()))
+			}
+		}
+		return strings.Join(tables, "\n\t\t")
 	}
+
+	counter := NewCounter()
+	for _, tok := range tokens {
+		if tok.Type == LITERAL {
+			continue
+		}
+		if err := counter.Add(&tok); err != nil {
+			return Stats{}, err
+		}
+	}
+	for _, c := range constructs {
+		for _, t := range c.Accumulate() {
+			if err := counter.Add(t); err != nil {
+				return Stats{}, err
+			}
+		}
+	}
+	tPrototypes, tDefinitions := counter.TokenData()
+	rPrototypes, rDefinitions := counter.RegexData()
+
 	lexBody, err := LexBody(tokens)
 	if err != nil {
-		return err
+		return Stats{}, err
 	}
 	staticRangesLen, trieInserts, err := KnownTrieInserts(tokens)
 	if err != nil {
-		return err
-	}
-	rPrototypes, rDefinitions, err := RegexData(constructs)
-	if err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	trie, err := os.ReadFile("util/Trie.hpp")
 	if err != nil {
-		return err
+		return Stats{}, err
 	}
 	if _, err := w.Write(trie); err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	b, err := os.ReadFile("util/Lexer.hpp")
 	if err != nil {
-		return err
+		return Stats{}, err
 	}
 
 	t := template.Must(template.New("").Parse(string(b)))
@@ -323,17 +304,116 @@ func writeLexerHpp(w io.Writer, tokens []Token, constructs []Construct) error {
 		"TokenDefinitions": tDefinitions,
 		"RegexDefinitions": rDefinitions,
 		"SkipTokenCalls":   SkipCalls(tokens),
+		"MemoTables":       MemoTables(constructs),
+		"Recovers":         recovers(constructs),
 	})
 	if err != nil {
-		return err
+		return Stats{}, err
 	}
-	return nil
+	return counter.Stats(), nil
+}
+
+// recovers tells whether any construct recovers from errors.
+func recovers(constructs []Construct) bool {
+	for _, c := range constructs {
+		if len(c.Sync) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNodeTypes lists every ParseNode type: one per construct, plus the
+// nodes operator tables build and the error node if anything recovers.
+func parseNodeTypes(constructs []Construct) []string {
+	types := []string{}
+	for _, c := range constructs {
+		types = append(types, c.Name())
+		if ops, ok := c.Value.(*OperatorRegex); ok {
+			types = append(types, ops.NodeTypes()...)
+		}
+	}
+	if recovers(constructs) {
+		types = append(types, errorNodeType)
+	}
+	return types
 }
 
 func writeParseNodeHpp(w io.Writer, constructs []Construct) error {
-	types := make([]string, len(constructs))
-	for i, c := range constructs {
-		types[i] = c.Name()
+	types := parseNodeTypes(constructs)
+
+	FieldNames := func(constructs []Construct) []string {
+		seen := map[string]bool{}
+		names := []string{}
+		for _, c := range constructs {
+			for _, f := range c.Fields {
+				if !seen[f.Name] {
+					seen[f.Name] = true
+					names = append(names, f.Name)
+				}
+			}
+		}
+		return names
+	}
+	// NameCases maps every value of an enum to its name in a switch.
+	NameCases := func(enum string, names []string) string {
+		cases := make([]string, len(names))
+		for i, name := range names {
+			cases[i] = fmt.Sprintf("case %s::%s: return %s;", enum, name, strconv.Quote(name))
+		}
+		return strings.Join(cases, "\n\t\t\t\t")
+	}
+	FieldAccessors := func(constructs []Construct) (string, error) {
+		var s strings.Builder
+		for _, c := range constructs {
+			if len(c.Fields) == 0 {
+				continue
+			}
+
+			accessors := []string{}
+			for _, f := range c.Fields {
+				var text string
+				switch f.Kind {
+				case SINGLE_FIELD:
+					text = "const Node &{{.Field}}() const { return *_node.field(ParseNode::Field::{{.Field}}).front(); }"
+				case OPTIONAL_FIELD:
+					text = "const Node *{{.Field}}() const { auto f = _node.field(ParseNode::Field::{{.Field}}); return f.empty() ? nullptr : f.front(); }"
+				default:
+					text = "std::vector<const Node *> {{.Field}}() const { return _node.field(ParseNode::Field::{{.Field}}); }"
+				}
+				a, err := WriteString(text, map[string]any{"Field": f.Name})
+				if err != nil {
+					return "", err
+				}
+				accessors = append(accessors, a)
+			}
+
+			a, err := WriteString(
+				`
+	struct {{.Name}}Fields {
+		const ParseNode &_node;
+
+		explicit {{.Name}}Fields(const ParseNode &node) : _node(node) {}
+
+		{{.Accessors}}
+	};
+	`,
+				map[string]any{
+					"Name":      c.Name(),
+					"Accessors": strings.Join(accessors, "\n\t\t"),
+				},
+			)
+			if err != nil {
+				return "", err
+			}
+			s.WriteString(a)
+		}
+		return s.String(), nil
+	}
+
+	accessors, err := FieldAccessors(constructs)
+	if err != nil {
+		return err
 	}
 
 	b, err := os.ReadFile("util/ParseNode.hpp")
@@ -342,8 +422,13 @@ func writeParseNodeHpp(w io.Writer, constructs []Construct) error {
 	}
 
 	t := template.Must(template.New("").Parse(string(b)))
+	fields := FieldNames(constructs)
 	err = t.Execute(w, map[string]any{
 		"ParseNodeTypes": strings.Join(types, ",\n"),
+		"FieldNames":     strings.Join(fields, ",\n"),
+		"TypeNameCases":  NameCases("Type", types),
+		"FieldNameCases": NameCases("Field", fields),
+		"FieldAccessors": accessors,
 	})
 	if err != nil {
 		return err
@@ -356,11 +441,14 @@ func writeParserHpp(w io.Writer, constructs []Construct) error {
 	for _, c := range constructs {
 		if c.EntryPoint {
 			if ep != nil {
-				return fmt.Errorf("Only one entry point allowed! Previous entry point was '%s', found entry point '%s'.", ep.Name(), c.Name())
+				return errorAt(c.Pos, "Only one entry point allowed! Previous entry point was '%s' at %s, found entry point '%s'.", ep.Name(), ep.Pos, c.Name())
 			}
 			ep = &c
 		}
 	}
+	if ep == nil {
+		return fmt.Errorf("No entry point found! Mark one construct with '->'.")
+	}
 
 	b, err := os.ReadFile("util/Parser.hpp")
 	if err != nil {
@@ -369,7 +457,7 @@ func writeParserHpp(w io.Writer, constructs []Construct) error {
 
 	t := template.Must(template.New("").Parse(string(b)))
 	err = t.Execute(w, map[string]any{
-		"EntryPointRegexCall": ep.Call("const_cast<ParseNode &>(node.node()).children()"),
+		"EntryPointRegexCall": fmt.Sprintf("build(node.node(), &Lexer::construct%s)", ep.Name()),
 		"EntryPointType":      fmt.Sprintf("ParseNode::Type::%s", ep.Name()),
 	})
 	if err != nil {
@@ -381,9 +469,9 @@ func writeParserHpp(w io.Writer, constructs []Construct) error {
 func writeVisitorHpp(w io.Writer, chiselPath string, constructs []Construct) error {
 	var mainSwitch strings.Builder
 	var cVisitors strings.Builder
-	for _, c := range constructs {
-		mainSwitch.WriteString(fmt.Sprintf("case ParseNode::Type::%s: return static_cast<Base *>(this)->Base::visit%s(node, pass_count);\n\t\t\t\t", c.Name(), c.Name()))
-		cVisitors.WriteString(fmt.Sprintf(`virtual ReturnType visit%s(const ParseNode &node, int pass_count) = 0;%s`, c.Name(), "\n\n\t\t"))
+	for _, t := range parseNodeTypes(constructs) {
+		mainSwitch.WriteString(fmt.Sprintf("case ParseNode::Type::%s: return static_cast<Base *>(this)->Base::visit%s(node, pass_count);\n\t\t\t\t", t, t))
+		cVisitors.WriteString(fmt.Sprintf(`virtual ReturnType visit%s(const ParseNode &node, int pass_count) = 0;%s`, t, "\n\n\t\t"))
 	}
 
 	b, err := os.ReadFile("util/visitor.hpp")
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -10,8 +12,24 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		analyze(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		format(os.Args[2:])
+		return
+	}
+
 	outputPath := flag.String("o", "chisel.hpp", "The library output file path (default='chisel.hpp').")
 	visitorPath := flag.String("v", "visitor.hpp", "The visitor output file path (default='visitor.hpp').")
+	predictive := flag.Bool("predictive", false, "Dispatch on the next token in every construct, not just those marked 'predictive'.")
+	packrat := flag.Bool("packrat", false, "Memoize every construct, not just those marked 'packrat'.")
+	dumpIR := flag.Bool("dump-ir", false, "Print the constructs after realizing them and after every optimization pass.")
+	skip := map[string]*bool{}
+	for _, p := range grammar.Passes {
+		skip[p.Name] = flag.Bool("no-"+p.Name, false, "Don't run the '"+p.Name+"' pass: "+p.Doc)
+	}
 	flag.Parse()
 	filePath := flag.Arg(0)
 
@@ -36,7 +54,92 @@ func main() {
 	}
 	defer v.Close()
 
-	if err := grammar.Chisel(r, w, *outputPath, v); err != nil {
+	opts := grammar.Options{
+		Predictive: *predictive,
+		Packrat:    *packrat,
+		Skip:       map[string]bool{},
+	}
+	for name, off := range skip {
+		opts.Skip[name] = *off
+	}
+	if *dumpIR {
+		opts.DumpIR = os.Stdout
+	}
+	stats, err := grammar.Chisel(r, filePath, w, *outputPath, v, opts)
+	if err != nil {
+		log.Fatal("Chisel failure: ", err)
+	}
+	fmt.Printf("functions %d for %d uses, %d shared\n", stats.Functions, stats.Uses, stats.Shared)
+}
+
+// analyze implements `chisel analyze [-json] grammar.chisel`, printing the
+// nullable, FIRST and FOLLOW sets and the LL(1) conflicts of a grammar.
+func analyze(args []string) {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	asJson := flags.Bool("json", false, "Print the report as JSON.")
+	flags.Parse(args)
+	filePath := flags.Arg(0)
+
+	r, err := os.Open(filePath)
+	if err != nil {
+		log.Fatal("Failed to open file: ", err)
+	}
+	defer r.Close()
+
+	analysis, err := grammar.Analyze(r, filePath)
+	if err != nil {
 		log.Fatal("Chisel failure: ", err)
 	}
+
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(analysis)
+	} else {
+		err = analysis.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal("Failed to write report: ", err)
+	}
+}
+
+// format implements `chisel fmt [-check] grammar.chisel...`, rewriting each
+// grammar in the canonical style. With -check nothing is written: the files
+// that aren't formatted are listed, and the exit status is 1 if there are any.
+func format(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "List the files that aren't formatted instead of rewriting them.")
+	flags.Parse(args)
+
+	unformatted := false
+	for _, filePath := range flags.Args() {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			log.Fatal("Failed to open file: ", err)
+		}
+		text, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Fatal("Failed to open file: ", err)
+		}
+
+		formatted, err := grammar.Format(text, filePath)
+		if err != nil {
+			log.Fatal("Chisel failure: ", err)
+		}
+		if bytes.Equal(text, formatted) {
+			continue
+		}
+		if *check {
+			fmt.Println(filePath)
+			unformatted = true
+			continue
+		}
+		if err := os.WriteFile(filePath, formatted, info.Mode()); err != nil {
+			log.Fatal("Failed to write file: ", err)
+		}
+	}
+	if unformatted {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cactircool/chisel/grammar"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+// TestMain runs chisel itself instead of the tests when CHISEL_MAIN is set,
+// which is how run invokes the command.
+func TestMain(m *testing.M) {
+	if os.Getenv("CHISEL_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs chisel with args, returning its standard output and exit status.
+func run(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CHISEL_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("chisel %v failed: %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String(), 0
+}
+
+func TestAnalyzeJSON(t *testing.T) {
+	got, status := run(t, "analyze", "-json", "testdata/analyze.chisel")
+	if status != 0 {
+		t.Fatalf("chisel analyze exited with status %d", status)
+	}
+	golden := "testdata/analyze.json"
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("chisel analyze -json printed\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFmtCheck(t *testing.T) {
+	dir := t.TempDir()
+	formatted := filepath.Join(dir, "formatted.chisel")
+	unformatted := filepath.Join(dir, "unformatted.chisel")
+	messy := "tok ID=/[a-z]+/\n->p=ID  ;\n"
+	if err := os.WriteFile(formatted, []byte("tok ID = /[a-z]+/\n-> p = ID;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unformatted, []byte(messy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, status := run(t, "fmt", "-check", formatted); status != 0 || out != "" {
+		t.Errorf("fmt -check on a formatted file printed %q and exited with %d, want nothing and 0", out, status)
+	}
+	if out, status := run(t, "fmt", "-check", formatted, unformatted); status != 1 || out != unformatted+"\n" {
+		t.Errorf("fmt -check printed %q and exited with %d, want %q and 1", out, status, unformatted+"\n")
+	}
+	if text, err := os.ReadFile(unformatted); err != nil || string(text) != messy {
+		t.Errorf("fmt -check rewrote %s", unformatted)
+	}
+
+	if _, status := run(t, "fmt", unformatted); status != 0 {
+		t.Fatalf("fmt exited with %d", status)
+	}
+	if out, status := run(t, "fmt", "-check", formatted, unformatted); status != 0 || out != "" {
+		t.Errorf("fmt -check after fmt printed %q and exited with %d, want nothing and 0", out, status)
+	}
+}
+
+func TestNoPassFlags(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range grammar.Passes {
+		t.Run(p.Name, func(t *testing.T) {
+			out, status := run(t, "-no-"+p.Name, "-dump-ir", "-o", filepath.Join(dir, "chisel.hpp"), "-v", filepath.Join(dir, "visitor.hpp"), "testdata/analyze.chisel")
+			if status != 0 {
+				t.Fatalf("chisel -no-%s exited with %d", p.Name, status)
+			}
+			steps := []string{}
+			for _, line := range strings.Split(out, "\n") {
+				if step, ok := strings.CutPrefix(line, "== "); ok {
+					steps = append(steps, step)
+				}
+			}
+			want := []string{"realize"}
+			for _, q := range grammar.Passes {
+				if q.Name != p.Name {
+					want = append(want, q.Name)
+				}
+			}
+			if strings.Join(steps, " ") != strings.Join(want, " ") {
+				t.Errorf("chisel -no-%s ran %v, want %v", p.Name, steps, want)
+			}
+		})
+	}
+}
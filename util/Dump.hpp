@@ -0,0 +1,169 @@
+#include <ostream>
+#include <sstream>
+#include <string>
+
+namespace chisel {
+
+	namespace dump {
+
+		// Writes text as the inside of a JSON string, which DOT labels and
+		// S-expressions read the same way.
+		inline void escape(std::ostream &out, const char *text, size_t length) {
+			for (size_t i = 0; i < length; ++i) {
+				auto c = static_cast<unsigned char>(text[i]);
+				switch (c) {
+					case '"': out << "\\\""; break;
+					case '\\': out << "\\\\"; break;
+					case '\n': out << "\\n"; break;
+					case '\r': out << "\\r"; break;
+					case '\t': out << "\\t"; break;
+					default:
+						if (c < 0x20) {
+							static const char *digits = "0123456789abcdef";
+							out << "\\u00" << digits[c >> 4] << digits[c & 0xf];
+						} else {
+							out << text[i];
+						}
+				}
+			}
+		}
+
+		inline void quote(std::ostream &out, const char *text) {
+			out << '"';
+			escape(out, text, strlen(text));
+			out << '"';
+		}
+
+		inline void quote(std::ostream &out, const Token &token) {
+			out << '"';
+			escape(out, token.data(), token.length());
+			out << '"';
+		}
+
+		// Literal tokens are named after their text, already quoted.
+		inline bool anonymous(const Token &token) {
+			return Token::name(token.type())[0] == '"';
+		}
+
+		inline void json(std::ostream &out, const Node &node, const char *field) {
+			out << '{';
+			if (field) {
+				out << "\"field\":";
+				quote(out, field);
+				out << ',';
+			}
+			if (node.holds_token()) {
+				out << "\"token\":";
+				quote(out, Token::name(node.token().type()));
+				out << ",\"text\":";
+				quote(out, node.token());
+				out << '}';
+				return;
+			}
+
+			const auto &parse = node.node();
+			out << "\"type\":";
+			quote(out, ParseNode::name(parse.type()));
+			out << ",\"children\":[";
+			for (size_t i = 0; i < parse.size(); ++i) {
+				if (i)
+					out << ',';
+				auto f = parse.field_of(i);
+				json(out, parse.child(i), f ? ParseNode::name(*f) : nullptr);
+			}
+			out << "]}";
+		}
+
+		inline void sexp(std::ostream &out, const Node &node) {
+			if (node.holds_token()) {
+				if (anonymous(node.token())) {
+					out << Token::name(node.token().type());
+					return;
+				}
+				out << '(' << Token::name(node.token().type()) << ' ';
+				quote(out, node.token());
+				out << ')';
+				return;
+			}
+
+			const auto &parse = node.node();
+			out << '(' << ParseNode::name(parse.type());
+			for (size_t i = 0; i < parse.size(); ++i) {
+				out << ' ';
+				if (auto f = parse.field_of(i))
+					out << ParseNode::name(*f) << ": ";
+				sexp(out, parse.child(i));
+			}
+			out << ')';
+		}
+
+		// Writes node as n<id> and returns the next free id.
+		inline size_t dot(std::ostream &out, const Node &node, size_t id) {
+			out << "\tn" << id << " [label=\"";
+			if (node.holds_token()) {
+				auto name = Token::name(node.token().type());
+				escape(out, name, strlen(name));
+				if (!anonymous(node.token())) {
+					out << "\\n";
+					std::stringstream text;
+					quote(text, node.token());
+					auto s = text.str();
+					escape(out, s.data(), s.size());
+				}
+				out << "\", shape=ellipse];\n";
+				return id + 1;
+			}
+
+			const auto &parse = node.node();
+			out << ParseNode::name(parse.type()) << "\"];\n";
+			auto next = id + 1;
+			for (size_t i = 0; i < parse.size(); ++i) {
+				out << "\tn" << id << " -> n" << next;
+				if (auto f = parse.field_of(i))
+					out << " [label=\"" << ParseNode::name(*f) << "\"]";
+				out << ";\n";
+				next = dot(out, parse.child(i), next);
+			}
+			return next;
+		}
+
+	}
+
+	// Writes the tree as JSON. A node is {"type": ..., "children": [...]} and
+	// a token {"token": ..., "text": ...}, with "field" on labeled children.
+	inline void to_json(std::ostream &out, const Node &node) {
+		dump::json(out, node, nullptr);
+	}
+
+	// Writes the tree as an S-expression in the style of tree-sitter:
+	// (stmt name: (ID "x") "=" value: (ID "y")).
+	inline void to_sexp(std::ostream &out, const Node &node) {
+		dump::sexp(out, node);
+	}
+
+	// Writes the tree as a Graphviz digraph, fields labeling the edges.
+	inline void to_dot(std::ostream &out, const Node &node) {
+		out << "digraph {\n\tnode [shape=box];\n";
+		dump::dot(out, node, 0);
+		out << "}\n";
+	}
+
+	inline std::string to_json(const Node &node) {
+		std::stringstream ss;
+		to_json(ss, node);
+		return ss.str();
+	}
+
+	inline std::string to_sexp(const Node &node) {
+		std::stringstream ss;
+		to_sexp(ss, node);
+		return ss.str();
+	}
+
+	inline std::string to_dot(const Node &node) {
+		std::stringstream ss;
+		to_dot(ss, node);
+		return ss.str();
+	}
+
+}
@@ -1,16 +1,24 @@
+#include <initializer_list>
+#include <limits>
+#include <optional>
+#include <unordered_map>
+#include <utility>
+
 namespace chisel {
 
 	class Lexer {
 		Reader &reader;
 		Trie tries[{{.NumTries}}];
+		ParseNode *current = nullptr;
+		std::vector<Diagnostic> _diagnostics;
+
+		// The failure that got furthest into the input since the last one
+		// reported, which says more than whatever gave up last.
+		std::optional<Diagnostic> _furthest;
 
 		{{.TokenPrototypes}}
 
 	protected:
-		std::string error(const std::string &msg) const {
-			return reader.error(msg);
-		}
-
 		void skip() {
 			{{.SkipTokenCalls}}
 		}
@@ -20,12 +28,278 @@ namespace chisel {
 			{{.LexBody}}
 		}
 
+		// A position in the input to go back to with rewind().
+		struct Mark {
+			std::streampos position;
+			std::ios_base::iostate state;
+			size_t line;
+			size_t column;
+		};
+
+		Mark mark() {
+			auto state = reader.rdstate();
+			reader.clear();
+			Mark res { reader.tellg(), state, reader.line(), reader.column() };
+			reader.clear(state);
+			return res;
+		}
+
+		// Whether the input moved on since from. Loops stop after a round
+		// that matched without consuming anything, as every round after it
+		// would do the same.
+		bool advanced(const Mark &from) {
+			return mark().position != from.position;
+		}
+
+		void rewind(const Mark &mark) {
+			reader.clear();
+			reader.seekg(mark.position);
+			reader.clear(mark.state);
+		}
+
+		// Where a regex started: the input position, and how much there was
+		// of the nodes it adds to, the fields and separators of the node it
+		// builds and the errors reported. Every regex function either matches
+		// or rolls back to where it started, so what runs after a failure,
+		// like the next alternative, sees the input, the tree and the errors
+		// as they were.
+		struct Checkpoint {
+			Mark mark;
+			size_t nodes;
+			size_t fields;
+			size_t separators;
+			size_t diagnostics;
+		};
+
+		Checkpoint checkpoint(const std::vector<Node> &nodes) {
+			return { mark(), nodes.size(), current->fields_size(), current->separators().size(), _diagnostics.size() };
+		}
+
+		// Errors recovered from in the part given up on are taken back, and
+		// so is whatever failed after them, which only failed because of
+		// them.
+		void rollback(const Checkpoint &checkpoint, std::vector<Node> &nodes) {
+			rewind(checkpoint.mark);
+			nodes.erase(nodes.begin() + checkpoint.nodes, nodes.end());
+			current->truncate(checkpoint.fields, checkpoint.separators);
+			if (_diagnostics.size() > checkpoint.diagnostics) {
+				_diagnostics.erase(_diagnostics.begin() + checkpoint.diagnostics, _diagnostics.end());
+				_furthest.reset();
+			}
+		}
+
+		// What a packrat construct did at some position: its result, where it
+		// stopped, the nodes it produced and the errors it recovered from.
+		struct Memo {
+			Result result;
+			Mark end;
+			std::vector<Node> nodes;
+			std::vector<Diagnostic> diagnostics;
+		};
+
+		{{.MemoTables}}
+
+		// Keeps token, lexed from start, if it's longer than best, which ends
+		// at end. Ties go to best, so the first token tried wins them.
+		void longest(const Mark &start, Token token, Token &best, Mark &end) {
+			auto at = mark();
+			if (token && (!best || at.position > end.position)) {
+				best = std::move(token);
+				end = at;
+			}
+			rewind(start);
+		}
+
+		// Lexes the next token without consuming it.
+		Token peek() {
+			auto start = mark();
+			auto token = lex();
+			rewind(start);
+			return token;
+		}
+
+		bool at_end() {
+			skip();
+			return reader.peek() == std::char_traits<char>::eof();
+		}
+
+		static bool among(Token::Type type, std::initializer_list<Token::Type> types) {
+			return std::find(types.begin(), types.end(), type) != types.end();
+		}
+
+		static Span span(const Mark &begin, const Mark &end) {
+			return { static_cast<std::streamoff>(begin.position), static_cast<std::streamoff>(end.position), begin.line, begin.column };
+		}
+
+		static std::string describe(const Token &actual, bool end, const std::vector<Token::Type> &expected) {
+			std::stringstream ss;
+			if (end)
+				ss << "Unexpected end of input";
+			else if (!actual)
+				ss << "Unknown token";
+			else
+				ss << "Unexpected '" << actual.data() << "' of type '" << Token::name(actual.type()) << "'";
+			if (expected.empty())
+				ss << ", expected the end of the input";
+			for (size_t i = 0; i < expected.size(); ++i)
+				ss << (i ? ", " : expected.size() == 1 ? ", expected " : ", expected one of ") << '\'' << Token::name(expected[i]) << '\'';
+			ss << "!";
+			return ss.str();
+		}
+
+		// Keeps the diagnostic if it got furthest. Token failures at the same
+		// place are merged, so the diagnostic lists everything expected there.
+		void track(const Diagnostic &diagnostic, bool end) {
+			if (_furthest && diagnostic.span.begin < _furthest->span.begin)
+				return;
+			if (_furthest && diagnostic.span.begin == _furthest->span.begin) {
+				if (diagnostic.expected.empty() || _furthest->expected.empty())
+					return;
+				for (auto type : diagnostic.expected)
+					if (std::find(_furthest->expected.begin(), _furthest->expected.end(), type) == _furthest->expected.end())
+						_furthest->expected.push_back(type);
+				_furthest->message = describe(_furthest->actual, end, _furthest->expected);
+				return;
+			}
+			_furthest = diagnostic;
+		}
+
+		// Fails here with msg.
+		Result error(const std::string &msg) {
+			auto at = mark();
+			Diagnostic diagnostic;
+			diagnostic.span = span(at, at);
+			diagnostic.message = msg;
+			track(diagnostic, false);
+			return diagnostic;
+		}
+
+		// Fails on token, lexed from start, where one of expected should have
+		// been. The input is left at start.
+		Result unexpected(const Mark &start, const Token &token, std::initializer_list<Token::Type> expected) {
+			Diagnostic diagnostic;
+			diagnostic.span = span(start, mark());
+			diagnostic.expected = expected;
+			diagnostic.actual = token;
+			rewind(start);
+			bool end = !token && reader.peek() == std::char_traits<char>::eof();
+			reader.clear(start.state);
+			diagnostic.message = describe(token, end, diagnostic.expected);
+			track(diagnostic, end);
+			return diagnostic;
+		}
+
+		// Tracks that one of expected could have come next, as a failed match
+		// would, without moving the input.
+		void expect(std::initializer_list<Token::Type> expected) {
+			auto before = mark();
+			skip();
+			auto start = mark();
+			unexpected(start, lex(), expected);
+			rewind(before);
+		}
+
+		// Records failure, or a failure since from that got further.
+		void report(const Result &failure, std::streamoff from) {
+			if (_furthest && _furthest->span.begin >= from)
+				_diagnostics.push_back(*_furthest);
+			else
+				_diagnostics.push_back(failure.diagnostic());
+			_furthest.reset();
+		}
+		{{- if .Recovers}}
+
+		// Puts back the failure tracked before a recovering construct ran,
+		// which was cleared so that only what failed inside the construct
+		// explains why it did, unless the construct got at least as far.
+		void restore(std::optional<Diagnostic> outer) {
+			if (outer && (!_furthest || outer->span.begin > _furthest->span.begin))
+				_furthest = std::move(outer);
+		}
+
+		// Called when a recovering construct that began at start fails, and
+		// rolled back to it. Unless it failed right away on something that
+		// can legally follow it, the input up to the next sync token is
+		// skipped and node becomes an Error node holding the skipped tokens.
+		// A sync token that can follow the construct is left for the caller.
+		Result recover(const Mark &start, Node &node, const Result &failure, std::initializer_list<Token::Type> sync, std::initializer_list<Token::Type> follow) {
+			auto from = static_cast<std::streamoff>(start.position);
+			if (!_furthest || _furthest->span.begin <= from) {
+				if (at_end())
+					return failure;
+				auto token = peek();
+				if (token && among(token.type(), follow))
+					return failure;
+			}
+			report(failure, from);
+
+			Node error(new ParseNode(ParseNode::Type::Error));
+			while (!at_end()) {
+				auto before = mark();
+				auto token = lex();
+				if (!token) {
+					rewind(before);
+					skip();
+					reader.get();
+					continue;
+				}
+				if (among(token.type(), sync)) {
+					if (among(token.type(), follow))
+						rewind(before);
+					else
+						error.node().append(std::move(token));
+					break;
+				}
+				error.node().append(std::move(token));
+			}
+			node = std::move(error);
+			return {};
+		}
+		{{- end}}
+
 	public:
 		Lexer(Reader &reader) : reader(reader) {
 			{{.KnownTrieInserts}}
 		}
 		~Lexer() = default;
 
+		// Fails unless the whole input has been read.
+		Result finish() {
+			if (at_end())
+				return {};
+			auto start = mark();
+			auto token = lex();
+			return unexpected(start, token, {});
+		}
+
+		// Records a failure the parse couldn't recover from.
+		void fail(const Result &failure) {
+			report(failure, 0);
+		}
+
+		const std::vector<Diagnostic> &diagnostics() const {
+			return _diagnostics;
+		}
+
+		// Runs a construct with node as the node its children and fields go to.
+		Result build(ParseNode &node, Result (Lexer::*construct)(std::vector<Node> &)) {
+			auto *parent = current;
+			current = &node;
+			auto res = (this->*construct)(node.children());
+			current = parent;
+			return res;
+		}
+
+		// The same for the body of an inlined construct.
+		template <typename Body>
+		Result build(ParseNode &node, Body body) {
+			auto *parent = current;
+			current = &node;
+			auto res = body(node.children());
+			current = parent;
+			return res;
+		}
+
 		{{.RegexPrototypes}}
 	};
 
@@ -1,51 +1,24 @@
 #include <cstring>
+#include <memory>
 namespace chisel {
 
-	class ParseNode;
+	struct ParseNode;
 
 	class Node {
-		union {
-			ParseNode *_node;
-			Token _token;
-		};
+		std::shared_ptr<ParseNode> _node;
+		Token _token;
 		bool _leaf;
 
 	public:
-		Node(ParseNode *node) : _node(node), _leaf(false) {}
+		Node(ParseNode *node);
 		Node(const Token &token) : _token(token), _leaf(true) {}
-		Node(Token &&token) : _token(token), _leaf(true) {}
+		Node(Token &&token) : _token(std::move(token)), _leaf(true) {}
 
-		Node(const Node &other) : _leaf(other._leaf) {
-			if (_leaf)
-				_token = other._token;
-			else
-				_node = other._node;
-		}
-		Node &operator=(const Node &other) {
-			_leaf = other._leaf;
-			if (_leaf)
-				_token = other._token;
-			else
-				_node = other._node;
-			return *this;
-		}
-
-		Node(Node &&other) : _leaf(other._leaf) {
-			if (_leaf)
-				_token = std::move(other._token);
-			else
-				_node = other._node;
-		}
-		Node &operator=(Node &&other) {
-			_leaf = other._leaf;
-			if (_leaf)
-				_token = std::move(other._token);
-			else
-				_node = other._node;
-			return *this;
-		}
-
-		~Node();
+		Node(const Node &other) = default;
+		Node &operator=(const Node &other) = default;
+		Node(Node &&other) noexcept = default;
+		Node &operator=(Node &&other) noexcept = default;
+		~Node() = default;
 
 		inline bool holds_token() const {
 			return _leaf;
@@ -57,6 +30,9 @@ namespace chisel {
 		inline const ParseNode &node() const {
 			return *_node;
 		}
+		inline ParseNode &node() {
+			return *_node;
+		}
 		inline const Token &token() const {
 			return _token;
 		}
@@ -1,3 +1,4 @@
+#include <optional>
 #include <vector>
 
 namespace chisel {
@@ -6,13 +7,37 @@ namespace chisel {
 		enum class Type {
 			{{.ParseNodeTypes}}
 		};
+		enum class Field {
+			{{.FieldNames}}
+		};
 	private:
+		struct FieldRange {
+			Field field;
+			size_t begin;
+			size_t end;
+		};
+
 		Type _type;
 		std::vector<Node> _children;
+		std::vector<FieldRange> _fields;
+		std::vector<Node> _separators;
 	public:
 		ParseNode(Type type) : _type(type) {}
 		~ParseNode() = default;
 
+		static const char *name(Type type) {
+			switch (type) {
+				{{.TypeNameCases}}
+			}
+			return "";
+		}
+		static const char *name(Field field) {
+			switch (field) {
+				{{.FieldNameCases}}
+			}
+			return "";
+		}
+
 		void append(Node &&node) { _children.emplace_back(node); }
 		void append(const Node &node) { _children.emplace_back(node); }
 
@@ -28,13 +53,69 @@ namespace chisel {
 
 		std::vector<Node> &children() { return _children; }
 		const std::vector<Node> &children() const { return _children; }
+
+		// Labels the children in [begin, end) with a field.
+		void mark(Field field, size_t begin, size_t end) { _fields.push_back({ field, begin, end }); }
+
+		// Keeps the nodes of a list separator out of the children.
+		void separate(std::vector<Node> &&separator) {
+			for (auto &node : separator)
+				_separators.push_back(std::move(node));
+		}
+
+		// Moves everything parsed so far into a new first child of the same
+		// type, so a left recursive construct can keep growing.
+		void nest() {
+			Node inner(new ParseNode(_type));
+			inner.node()._children = std::move(_children);
+			inner.node()._fields = std::move(_fields);
+			inner.node()._separators = std::move(_separators);
+			_children.clear();
+			_fields.clear();
+			_separators.clear();
+			_children.push_back(std::move(inner));
+		}
+
+		// Undoes nest(), dropping whatever was added after it.
+		void unnest() {
+			Node inner = std::move(_children.front());
+			_children = std::move(inner.node()._children);
+			_fields = std::move(inner.node()._fields);
+			_separators = std::move(inner.node()._separators);
+		}
+
+		// The field child i is labeled with, if any.
+		std::optional<Field> field_of(size_t i) const {
+			for (const auto &range : _fields)
+				if (range.begin <= i && i < range.end)
+					return range.field;
+			return std::nullopt;
+		}
+
+		size_t fields_size() const { return _fields.size(); }
+
+		// Drops the fields and separators recorded after the first ones.
+		void truncate(size_t fields, size_t separators) {
+			_fields.erase(_fields.begin() + fields, _fields.end());
+			_separators.erase(_separators.begin() + separators, _separators.end());
+		}
+
+		// The separators of every separated list in this node, in order.
+		const std::vector<Node> &separators() const { return _separators; }
+
+		// All children labeled with a field, in order.
+		std::vector<const Node *> field(Field field) const {
+			std::vector<const Node *> res;
+			for (const auto &range : _fields)
+				if (range.field == field)
+					for (auto i = range.begin; i < range.end && i < _children.size(); ++i)
+						res.push_back(&_children[i]);
+			return res;
+		}
 	};
 
-	Node::~Node() {
-		if (_leaf)
-			_token.~Token();
-		else
-			delete _node;
-	}
+	Node::Node(ParseNode *node) : _node(node), _leaf(false) {}
+
+	{{.FieldAccessors}}
 
 }
@@ -1,14 +1,57 @@
 namespace chisel {
+	// What parse() gives back: the tree if one could be built, and every
+	// diagnostic found. Errors a construct recovered from leave Error nodes in
+	// the tree, any other error leaves no tree at all.
+	class Outcome {
+		std::optional<Node> _tree;
+		std::vector<Diagnostic> _diagnostics;
+	public:
+		Outcome(std::vector<Diagnostic> diagnostics) : _diagnostics(std::move(diagnostics)) {}
+		Outcome(Node tree, std::vector<Diagnostic> diagnostics) : _tree(std::move(tree)), _diagnostics(std::move(diagnostics)) {}
+		~Outcome() = default;
+
+		bool has_tree() const {
+			return _tree.has_value();
+		}
+
+		// Throws std::bad_optional_access if there's no tree.
+		const Node &tree() const {
+			return _tree.value();
+		}
+		Node &tree() {
+			return _tree.value();
+		}
+
+		const std::vector<Diagnostic> &diagnostics() const {
+			return _diagnostics;
+		}
+
+		// Whether the input parsed without errors.
+		operator bool() const {
+			for (const auto &diagnostic : _diagnostics)
+				if (diagnostic.severity == Diagnostic::Severity::ERROR)
+					return false;
+			return _tree.has_value();
+		}
+	};
+
 	class Parser {
 		Lexer _lexer;
 	public:
 		Parser(Reader &reader) : _lexer(reader) {}
 		~Parser() = default;
 
-		Node parse() {
+		// Parses the whole input.
+		Outcome parse() {
 			Node node(new ParseNode({{.EntryPointType}}));
-			_lexer.{{.EntryPointRegexCall}};
-			return node;
+			auto res = _lexer.{{.EntryPointRegexCall}};
+			if (res)
+				res = _lexer.finish();
+			if (!res) {
+				_lexer.fail(res);
+				return Outcome(_lexer.diagnostics());
+			}
+			return Outcome(std::move(node), _lexer.diagnostics());
 		}
 	};
 }
@@ -1,24 +1,58 @@
 #include <string>
 #include <iostream>
+#include <memory>
+#include <vector>
 
 namespace chisel {
 
+	// A stretch of the input: byte offsets [begin, end), and the line and
+	// column begin is at, counting from 1.
+	struct Span {
+		std::streamoff begin = 0;
+		std::streamoff end = 0;
+		size_t line = 0;
+		size_t column = 0;
+	};
+
+	struct Diagnostic {
+		enum class Severity {
+			ERROR,
+			WARNING,
+		};
+
+		Severity severity = Severity::ERROR;
+		Span span;
+		std::string message;
+		// The token types that would have been accepted at span.
+		std::vector<Token::Type> expected;
+		// The token found at span instead, failed at the end of the input or
+		// where no token matches.
+		Token actual;
+
+		// Renders the diagnostic as "line:column severity: message".
+		std::string str() const {
+			std::stringstream ss;
+			ss << span.line << ":" << span.column << ' ' << (severity == Severity::ERROR ? "error" : "warning") << ": " << message;
+			return ss.str();
+		}
+	};
+
+	// What running part of the grammar gave: nothing on success, or the
+	// diagnostic it failed with.
 	class Result {
-		std::string msg;
+		std::shared_ptr<const Diagnostic> _diagnostic;
 	public:
-		Result() : msg() {}
-		Result(const std::string &msg) : msg(msg) {}
-		Result(std::string &&msg) : msg(msg) {}
+		Result() = default;
+		Result(Diagnostic &&diagnostic) : _diagnostic(std::make_shared<const Diagnostic>(std::move(diagnostic))) {}
 		~Result() = default;
 
-		void panic() const {
-			if (msg.empty()) return;
-			std::cerr << msg << std::endl;
-			exit(1);
+		// Only valid on failure.
+		const Diagnostic &diagnostic() const {
+			return *_diagnostic;
 		}
 
 		operator bool() const {
-			return msg.empty();
+			return !_diagnostic;
 		}
 	};
 
@@ -23,8 +23,10 @@ namespace chisel {
 
 		ReturnType visit() {
 			++pass_count;
-			auto root = parser.parse();
-			return visit(root.node(), pass_count);
+			auto outcome = parser.parse();
+			if (!outcome.has_tree())
+				throw std::runtime_error(outcome.diagnostics().back().str());
+			return visit(outcome.tree().node(), pass_count);
 		}
 	};
 